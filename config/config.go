@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,11 +13,15 @@ type Config struct {
     Port          string
     DatabaseURL   string // Supabase Postgres connection string
     JWTSecret     string
+    AccessTokenTTL  time.Duration
+    RefreshTokenTTL time.Duration
     OTPRequestURL string
     OTPVerifyURL  string
+    AIProvider    string // "gemini" (default) or "fake" for offline runs
     GeminiAPIKey  string
     GeminiModel   string
     GeminiEmbeddingModel string
+    JobWorkers    int // background job workers in this process; 0 disables them
 }
 
 func Load() Config {
@@ -24,15 +30,27 @@ func Load() Config {
         Port:          get("PORT", "8080"),
         DatabaseURL:   must("SUPABASE_DB_URL"),
         JWTSecret:     must("JWT_SECRET"),
+        AccessTokenTTL:  getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+        RefreshTokenTTL: getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
         OTPRequestURL: get("OTP_REQUEST_URL", "https://scalingwolf.ai/loginpage/request-otp"),
         OTPVerifyURL:  get("OTP_VERIFY_URL", "https://scalingwolf.ai/loginpage/verify-otp"),
+        AIProvider:    get("AI_PROVIDER", "gemini"),
         GeminiAPIKey:  get("GEMINI_API_KEY", ""),
         GeminiModel:   get("GEMINI_MODEL", "gemini-2.5-pro"),
         GeminiEmbeddingModel: get("GEMINI_EMBEDDING_MODEL", "text-embedding-004"),
+        JobWorkers:    getInt("JOB_WORKERS", 2),
     }
     return cfg
 }
 
+// AIEnabled reports whether model calls can be made with the selected provider.
+func (c Config) AIEnabled() bool {
+    if c.AIProvider == "fake" {
+        return true
+    }
+    return c.GeminiAPIKey != ""
+}
+
 func get(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -40,6 +58,30 @@ func get(k, def string) string {
 	return def
 }
 
+func getDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid duration for %s: %q", k, v)
+	}
+	return d
+}
+
+func getInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("invalid integer for %s: %q", k, v)
+	}
+	return n
+}
+
 func must(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
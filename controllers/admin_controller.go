@@ -0,0 +1,158 @@
+package controllers
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "scalingwolf-ai/backend/database"
+)
+
+type SetPlanRequest struct {
+    Plan      string `json:"plan"`            // plan code; sets quota to the plan's points
+    Points    int    `json:"points"`          // optional override, 1 point = 10,000 tokens
+    ResetUsed bool   `json:"reset_used"`      // optional: reset usage to 0
+}
+
+type AdminUser struct {
+    ID          int64      `json:"id"`
+    Name        string     `json:"name"`
+    Email       string     `json:"email"`
+    Phone       string     `json:"phone"`
+    Role        string     `json:"role"`
+    Plan        string     `json:"plan"`
+    DisabledAt  *time.Time `json:"disabled_at"`
+    TokenQuota  int64      `json:"token_quota"`
+    TokenUsed   int64      `json:"token_used"`
+    CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AdminListUsers lists accounts with their quota, newest first. Query: q
+// (matches name or email), limit, offset.
+func AdminListUsers() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+        offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+        if limit <= 0 || limit > 200 { limit = 50 }
+        if offset < 0 { offset = 0 }
+        q := strings.TrimSpace(c.Query("q"))
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        rows, err := database.Pool.Query(ctx, `
+            SELECT u.id, u.name, u.email, u.phone, u.role, COALESCE(p.code, $4), u.disabled_at,
+                   COALESCE(t.token_quota, 0)::bigint, COALESCE(t.token_used, 0)::bigint, u.created_at
+            FROM users u
+            LEFT JOIN token_quotas t ON t.user_id = u.id
+            LEFT JOIN plans p ON p.id = t.plan_id
+            WHERE $1 = '' OR u.email ILIKE '%' || $1 || '%' OR u.name ILIKE '%' || $1 || '%'
+            ORDER BY u.created_at DESC
+            LIMIT $2 OFFSET $3`, q, limit, offset, defaultPlanCode)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []AdminUser{}
+        for rows.Next() {
+            var u AdminUser
+            if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Phone, &u.Role, &u.Plan, &u.DisabledAt, &u.TokenQuota, &u.TokenUsed, &u.CreatedAt); err != nil { continue }
+            out = append(out, u)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out, "limit": limit, "offset": offset})
+    }
+}
+
+// adminTarget parses :id and checks the user exists, writing the error
+// response itself when it does not.
+func adminTarget(ctx context.Context, c *gin.Context) (int64, bool) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil || id <= 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid user id"}); return 0, false }
+    var exists bool
+    _ = database.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)`, id).Scan(&exists)
+    if !exists { c.JSON(http.StatusNotFound, gin.H{"error":"user not found"}); return 0, false }
+    return id, true
+}
+
+// AdminSetPlan moves a user to a catalogue plan and/or overrides their
+// monthly quota in points, optionally resetting the current period's usage.
+func AdminSetPlan() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req SetPlanRequest
+        if err := c.ShouldBindJSON(&req); err != nil || (req.Plan == "" && req.Points <= 0) || req.Points < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"provide plan and/or points"}); return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        uid, ok := adminTarget(ctx, c)
+        if !ok { return }
+        if err := rollQuotaPeriod(ctx, uid); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        var planID int
+        var planPoints int
+        if req.Plan != "" {
+            if err := database.Pool.QueryRow(ctx, `SELECT id, monthly_points FROM plans WHERE code=$1`, req.Plan).Scan(&planID, &planPoints); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error":"unknown plan"}); return
+            }
+        } else {
+            _ = database.Pool.QueryRow(ctx, `SELECT plan_id FROM token_quotas WHERE user_id=$1`, uid).Scan(&planID)
+        }
+        points := req.Points
+        if points == 0 { points = planPoints }
+        quota := int64(points) * tokensPerPoint
+        _, err := database.Pool.Exec(ctx, `UPDATE token_quotas
+            SET plan_id=$2, token_quota=$3, token_used=CASE WHEN $4::boolean THEN 0 ELSE token_used END, updated_at=now()
+            WHERE user_id=$1`, uid, planID, quota, req.ResetUsed)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"ok", "user_id": uid, "points": points, "token_quota": quota})
+    }
+}
+
+// AdminResetUsage zeroes a user's usage for the current period without
+// touching the quota. The ledger keeps the individual calls.
+func AdminResetUsage() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        uid, ok := adminTarget(ctx, c)
+        if !ok { return }
+        if err := rollQuotaPeriod(ctx, uid); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        _, err := database.Pool.Exec(ctx, `UPDATE token_quotas SET token_used=0, updated_at=now() WHERE user_id=$1`, uid)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"ok", "user_id": uid})
+    }
+}
+
+// AdminDisableUser blocks an account from logging in and revokes its sessions.
+func AdminDisableUser() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        uid, ok := adminTarget(ctx, c)
+        if !ok { return }
+        if uid == c.GetInt64("user_id") { c.JSON(http.StatusBadRequest, gin.H{"error":"cannot disable your own account"}); return }
+        tx, err := database.Pool.Begin(ctx)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer tx.Rollback(ctx)
+        if _, err := tx.Exec(ctx, `UPDATE users SET disabled_at=COALESCE(disabled_at, now()) WHERE id=$1`, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        if err := tx.Commit(ctx); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"disabled", "user_id": uid})
+    }
+}
+
+// AdminEnableUser lifts a previous disable.
+func AdminEnableUser() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        uid, ok := adminTarget(ctx, c)
+        if !ok { return }
+        if _, err := database.Pool.Exec(ctx, `UPDATE users SET disabled_at=NULL WHERE id=$1`, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        c.JSON(http.StatusOK, gin.H{"status":"enabled", "user_id": uid})
+    }
+}
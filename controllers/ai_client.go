@@ -0,0 +1,18 @@
+package controllers
+
+import (
+    "context"
+
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/utils"
+)
+
+// newAI returns the model provider selected in config (Gemini or the offline
+// fake), metered by m so every call is quota-checked and charged to feature.
+func newAI(ctx context.Context, cfg config.Config, m utils.Meter, feature string) (utils.Provider, error) {
+    p, err := utils.NewAIClient(ctx, utils.AIConfig{Provider: cfg.AIProvider, APIKey: cfg.GeminiAPIKey, GenModel: cfg.GeminiModel, EmbedModel: cfg.GeminiEmbeddingModel})
+    if err != nil {
+        return nil, err
+    }
+    return utils.Metered(p, m, feature), nil
+}
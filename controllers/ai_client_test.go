@@ -0,0 +1,68 @@
+package controllers
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/utils"
+)
+
+// settleMeter grants every reservation and records the settled calls.
+type settleMeter struct {
+    settled []utils.MeteredCall
+}
+
+func (m *settleMeter) Reserve(ctx context.Context, feature string, tokens int64) (utils.Reservation, error) {
+    return settleHold{m}, nil
+}
+
+type settleHold struct{ m *settleMeter }
+
+func (h settleHold) Settle(call utils.MeteredCall) { h.m.settled = append(h.m.settled, call) }
+func (h settleHold) Release()                      {}
+
+func TestNewAISelectsProvider(t *testing.T) {
+    ctx := context.Background()
+    p, err := newAI(ctx, config.Config{AIProvider: utils.ProviderFake}, nil, "chat")
+    if err != nil {
+        t.Fatalf("newAI(fake): %v", err)
+    }
+    if _, ok := p.(*utils.FakeProvider); !ok {
+        t.Fatalf("newAI(fake) = %T, want *utils.FakeProvider", p)
+    }
+    if _, err := newAI(ctx, config.Config{AIProvider: "openai"}, nil, "chat"); err == nil {
+        t.Fatalf("newAI(openai) returned no error")
+    }
+
+    // With a meter the fake is wrapped, and calls are charged to the feature
+    m := &settleMeter{}
+    p, err = newAI(ctx, config.Config{AIProvider: utils.ProviderFake}, m, "chat")
+    if err != nil {
+        t.Fatalf("newAI(fake, meter): %v", err)
+    }
+    reply, err := p.Generate(ctx, "Hello there")
+    if err != nil || reply != "fake reply (Hello there)" {
+        t.Fatalf("Generate = %q, %v", reply, err)
+    }
+    if len(m.settled) != 1 || m.settled[0].Feature != "chat" || m.settled[0].Kind != utils.CallGenerate {
+        t.Fatalf("settled = %+v, want one chat generate call", m.settled)
+    }
+}
+
+func TestGeminiSummaryOffline(t *testing.T) {
+    cfg := config.Config{AIProvider: utils.ProviderFake}
+    m := &settleMeter{}
+    first := geminiSummary(context.Background(), cfg, m, 1234.5, 10, 8)
+    second := geminiSummary(context.Background(), cfg, m, 1234.5, 10, 8)
+    if first == "" || first != second {
+        t.Fatalf("summaries = %q, %q; want the same non-empty text", first, second)
+    }
+    if !strings.HasPrefix(first, "fake reply (") || !strings.Contains(first, "Total sales = 1234.50") {
+        t.Errorf("summary = %q, want the fake reply to the summary prompt", first)
+    }
+    if len(m.settled) != 2 || m.settled[0].Feature != "analysis_summary" {
+        t.Errorf("settled = %+v, want two analysis_summary calls", m.settled)
+    }
+}
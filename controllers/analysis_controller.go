@@ -3,234 +3,376 @@ package controllers
 import (
     "bytes"
     "context"
-    "encoding/csv"
     "encoding/json"
-    "crypto/sha256"
-    "encoding/hex"
+    "errors"
+    "fmt"
     "io"
+    "log"
     "math"
     "net/http"
-    "path/filepath"
-    "regexp"
     "strconv"
     "strings"
     "time"
 
     "github.com/gin-gonic/gin"
-    "github.com/google/generative-ai-go/genai"
     "github.com/xuri/excelize/v2"
-    "google.golang.org/api/option"
 
     "scalingwolf-ai/backend/config"
-    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/ingestion"
+    "scalingwolf-ai/backend/jobs"
     "scalingwolf-ai/backend/utils"
 )
 
-// UploadAnalyze handles CSV/XLSX upload, detects header + columns, cleans rows,
-// and returns metrics: total_sales, bill_row_count, unique_bill_count.
+// UploadAnalyze accepts a CSV/XLSX upload and queues it for analysis,
+// answering 202 with a job id; poll GET /api/jobs/:id for the metrics
+// (total_sales, bill_row_count, unique_bill_count). The optional form field
+// "sheet" names the workbook tab to use; by default the most sales-like wins.
+// Columns are detected without review; use UploadPreview and UploadConfirm
+// to check or correct them first.
 func UploadAnalyze(cfg config.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
-        // Accept multipart/form-data with field name "file"
-        file, header, err := c.Request.FormFile("file")
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "missing file (field 'file')"})
+        fileName, ext, buf, ok := readUploadFile(c)
+        if !ok {
             return
         }
-        defer file.Close()
 
-        // Read entire file into memory (simplifies parsing both CSV and XLSX)
-        buf, err := io.ReadAll(file)
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        id, err := jobs.Enqueue(ctx, c.GetInt64("user_id"), c.GetInt64("org_id"), jobUploadAnalyze, uploadParams{FileName: fileName, Ext: ext, Sheet: c.PostForm("sheet")}, buf)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+            log.Printf("upload analyze enqueue error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert error"})
             return
         }
+        c.JSON(http.StatusAccepted, gin.H{"job_id": id, "status": jobs.StatusQueued})
+    }
+}
 
-        ext := strings.ToLower(filepath.Ext(header.Filename))
-        if ext != ".csv" && ext != ".xlsx" && ext != ".xls" {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type; use .csv or .xlsx/.xls"})
-            return
-        }
+// readUploadFile reads the multipart field "file" and detects its format,
+// answering 400 itself when ok is false.
+func readUploadFile(c *gin.Context) (name, ext string, content []byte, ok bool) {
+    file, header, err := c.Request.FormFile("file")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "missing file (field 'file')"})
+        return "", "", nil, false
+    }
+    defer file.Close()
+
+    // Read entire file into memory (simplifies parsing both CSV and XLSX)
+    content, err = io.ReadAll(file)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+        return "", "", nil, false
+    }
+
+    // Go by content, not name: old POS exports often carry the wrong extension
+    ext = utils.DetectSpreadsheet(content)
+    if ext == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type; use .csv or .xlsx/.xls"})
+        return "", "", nil, false
+    }
+    return header.Filename, ext, content, true
+}
+
+// uploadParams is stored with an upload_analyze job; the file is its payload.
+type uploadParams struct {
+    FileName string `json:"file_name"`
+    Ext      string `json:"ext"` // detected format, see utils.DetectSpreadsheet
+    Sheet    string `json:"sheet,omitempty"` // workbook tab to analyze; empty picks the best
+    // Mapping is set for uploads confirmed through UploadConfirm: detection
+    // is skipped and the mapping is saved for the org under the header set
+    // of the chosen header row.
+    Mapping *confirmedMapping `json:"mapping,omitempty"`
+}
 
-        // Parse rows from file (no header yet)
-        allRows, err := readAllRows(buf, ext)
+// confirmedMapping is the header row and columns a user confirmed or chose.
+type confirmedMapping struct {
+    HeaderRow int    `json:"header_row"`
+    Sales     string `json:"sales_column"`
+    Bill      string `json:"bill_column"`
+    Date      string `json:"date_column,omitempty"`
+    Name      string `json:"name,omitempty"` // names the saved mapping, e.g. "Petpooja POS export"
+    // Rules are the cleaning rules to apply and save; nil uses and keeps the
+    // saved mapping's (or the defaults).
+    Rules *ingestion.Rules `json:"rules,omitempty"`
+}
+
+// runUploadAnalyze is the upload_analyze job: it detects header + columns,
+// cleans rows, stores the metrics and returns the same body UploadAnalyze
+// used to answer with.
+func runUploadAnalyze(cfg config.Config) jobs.Handler {
+    return func(ctx context.Context, job *jobs.Job) (any, error) {
+        var p uploadParams
+        if err := json.Unmarshal(job.Params, &p); err != nil {
+            return nil, jobs.Permanent(err)
+        }
+        // Parse rows from file (no header yet), from the requested or best sheet
+        sheets, err := readSheets(job.Payload, p.Ext)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-            return
+            return nil, jobs.Permanent(err)
         }
-        // Preview first 5 rows for AI detection
-        preview := firstNRows(allRows, 5)
-
-        // Detect header + columns: try cache -> Gemini -> heuristic
-        sig := signatureForPreview(preview)
-        headerRowIdx, salesHeaderText, billHeaderText, aiUsed, aiMsg := cachedMappingOrDetect(cfg, c.GetInt64("user_id"), sig, preview)
-        if headerRowIdx < 0 || salesHeaderText == "" || billHeaderText == "" {
-            // Fallback heuristic on the full data if AI failed
-            headerRowIdx, salesHeaderText, billHeaderText = heuristicDetect(allRows)
+        sheet, sheetScores, err := pickSheet(sheets, p.Sheet)
+        if err != nil {
+            return nil, jobs.Permanent(err)
         }
-        if headerRowIdx < 0 {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "could not detect header row"})
-            return
+        job.Progress(ctx, 20, "detecting columns")
+        // Detect header + columns: confirmed mapping, else saved mapping -> AI -> heuristic
+        meter := meterFor(cfg, job.UserID, job.OrgID)
+        detector := salesDetector(cfg, meter, job.OrgID)
+        if p.Mapping != nil {
+            detector = ingestion.Fixed(ingestion.Detection{HeaderRow: p.Mapping.HeaderRow, Sales: p.Mapping.Sales, Bill: p.Mapping.Bill, Date: p.Mapping.Date, Source: "confirmed", Rules: p.Mapping.Rules})
         }
-
-        // Build header from the detected row
-        headers := normalizeHeaders(allRows, headerRowIdx)
-        if len(headers) == 0 {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "empty header row"})
-            return
+        res, err := ingestion.New(detector).Run(ctx, sheet.Rows)
+        if err != nil {
+            return nil, jobs.Permanent(err)
         }
+        m := res.Metrics
 
-        // Map detected names to actual header names (case-insensitive + substring)
-        salesCol := findColumn(headers, salesHeaderText)
-        billCol := findColumn(headers, billHeaderText)
-        if salesCol == "" || billCol == "" {
-            c.JSON(http.StatusBadRequest, gin.H{
-                "error":            "could not match detected columns",
-                "headers":          headers,
-                "sales_detected":   salesHeaderText,
-                "bill_detected":    billHeaderText,
-            })
-            return
+        // Optional short summary via the AI provider
+        summary := ""
+        if cfg.AIEnabled() {
+            summary = geminiSummary(ctx, cfg, meter, m.TotalSales, m.BillRowCount, m.UniqueBillCount)
         }
-
-        // Build records (rows after header)
-        records := buildRecords(allRows, headerRowIdx, headers)
-
-        // Cleaning pipeline
-        preRows := len(records)
-        records = dropBlankRows(records)
-        droppedBlank := preRows - len(records)
-
-        records, removedSecond := dropIfSecondColumnTotalish(records, headers)
-        droppedSecond := len(removedSecond)
-
-        records, removedSummary := filterSummaryRows(records, billCol, salesCol)
-        droppedSummary := len(removedSummary)
-
-        // Enforce rule: use exactly rows with a bill present
-        used := make([]map[string]string, 0, len(records))
-        for _, r := range records {
-            if !isEffectivelyEmptyBill(r[billCol]) {
-                used = append(used, r)
-            }
+        if summary == "" {
+            summary = simpleSummary(m.TotalSales, m.BillRowCount, m.UniqueBillCount)
         }
 
-        // Sales sum strictly from the same rows
-        var totalSales float64
-        for _, r := range used {
-            v := toNumeric(r[salesCol])
-            if !math.IsNaN(v) {
-                totalSales += v
+        // Persist to DB sales_metrics (+ cleaned rows) and index a small RAG doc
+        job.Progress(ctx, 75, "saving")
+        metricsID, err := saveSalesResult(ctx, cfg, job.UserID, job.OrgID, p.FileName, sheet, res)
+        if err != nil {
+            return nil, fmt.Errorf("persist sales upload: %w", err)
+        }
+        // Only a user-confirmed mapping is saved, so a wrong guess is not replayed
+        if p.Mapping != nil {
+            if err := saveColumnMapping(ctx, job.UserID, job.OrgID, sheet.Rows, *p.Mapping); err != nil {
+                log.Printf("save column mapping: %v", err)
             }
         }
 
-        billRowsCount := len(used)
-        uniqueBill := uniqueCount(used, billCol)
-
-        // Optional short summary via Gemini
-        summary := ""
-        if cfg.GeminiAPIKey != "" {
-            summary = geminiSummary(cfg, totalSales, billRowsCount, uniqueBill)
+        meta := gin.H{
+            "file_name":     p.FileName,
+            "sheet":         sheet.Name,
+            "header_row":    res.Columns.HeaderRow,
+            "sales_column":  res.Columns.Sales,
+            "bill_column":   res.Columns.Bill,
+            "date_column":   res.Columns.Date,
+            "detected_by":   res.Detection.Source,
+            "ai_used":       res.Detection.Source == "ai",
+            "ai_message":    res.Detection.Note,
+            "status_column": res.Columns.Status,
+            "number_format": res.NumberFormat,
+            "rules":         res.Rules,
         }
-        if summary == "" {
-            summary = simpleSummary(totalSales, billRowsCount, uniqueBill)
+        if sheet.Dialect != nil {
+            meta["csv_dialect"] = sheet.Dialect
         }
-
-        // Persist to DB sales_metrics and index a small RAG doc
-        {
-            payload := map[string]any{
-                "file_name": header.Filename,
-                "headers": headers,
-            }
-            pb, _ := json.Marshal(payload)
-            ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-            defer cancel()
-            _, _ = database.Pool.Exec(ctx, `INSERT INTO sales_metrics(user_id, source_type, payload, total_sales, bill_row_count, unique_bill_count) VALUES($1,'file',$2::jsonb,$3,$4,$5)`, c.GetInt64("user_id"), string(pb), round2(totalSales), billRowsCount, uniqueBill)
-            // Upsert column mapping cache
-            _, _ = database.Pool.Exec(ctx, `INSERT INTO column_mappings(user_id, signature, header_row, sales_column, bill_column) VALUES($1,$2,$3,$4,$5)
-                ON CONFLICT (user_id, signature) DO UPDATE SET header_row=EXCLUDED.header_row, sales_column=EXCLUDED.sales_column, bill_column=EXCLUDED.bill_column`,
-                c.GetInt64("user_id"), sig, headerRowIdx, salesCol, billCol,
-            )
-            // Optional RAG index if Gemini key is configured
-            if cfg.GeminiAPIKey != "" {
-                doc := "Sales metrics summary: Total sales = " + strconv.FormatFloat(round2(totalSales), 'f', 2, 64) + ", bill rows = " + strconv.Itoa(billRowsCount) + ", unique bill IDs = " + strconv.Itoa(uniqueBill)
-                aiClient, err := utils.NewAIClient(ctx, utils.AIConfig{APIKey: cfg.GeminiAPIKey, GenModel: cfg.GeminiModel, EmbedModel: cfg.GeminiEmbeddingModel})
-                if err == nil {
-                    emb, err := utils.EmbedText(ctx, aiClient, cfg.GeminiEmbeddingModel, doc)
-                    aiClient.Close()
-                    if err == nil {
-                        vec := utils.VectorLiteral(emb)
-                        _, _ = database.Pool.Exec(ctx, `INSERT INTO rag_documents(user_id, content, metadata, embedding) VALUES($1,$2,$3::jsonb, $4::vector)`, c.GetInt64("user_id"), doc, `{"source":"sales_metrics"}`, vec)
-                    }
-                }
-            }
+        if len(sheetScores) > 1 {
+            // How every tab scored, so a wrong pick can be redone with "sheet"
+            meta["sheets"] = sheetScores
         }
 
         // Build response JSON similar to Python
         resp := gin.H{
+            "sales_metrics_id": metricsID,
             "summary": summary,
             "metrics": gin.H{
-                "total_sales":      round2(totalSales),
-                "bill_row_count":   billRowsCount,
-                "unique_bill_count": uniqueBill,
+                "total_sales":      round2(m.TotalSales),
+                "bill_row_count":   m.BillRowCount,
+                "unique_bill_count": m.UniqueBillCount,
             },
-            "meta": gin.H{
-                "file_name":    header.Filename,
-                "header_row":   headerRowIdx,
-                "sales_column": salesCol,
-                "bill_column":  billCol,
-                "ai_used":      aiUsed,
-                "ai_message":   aiMsg,
-            },
-            "cleaning": gin.H{
-                "dropped_blank_rows":          droppedBlank,
-                "dropped_totalish_second_col": droppedSecond,
-                "dropped_summary_rows":        droppedSummary,
-                "final_rows_used":             billRowsCount,
+            "revenue": gin.H{
+                "gross_sales":       round2(res.Revenue.GrossSales),
+                "returns":           round2(res.Revenue.Returns),
+                "net_sales":         round2(res.Revenue.NetSales),
+                "cancelled_sales":   round2(res.Revenue.CancelledSales),
+                "sale_rows":         res.Revenue.SaleRows,
+                "return_rows":       res.Revenue.ReturnRows,
+                "cancelled_rows":    res.Revenue.CancelledRows,
+                "unique_sale_bills": res.Revenue.UniqueSaleBills,
             },
+            "bills": res.Bills,
+            "meta": meta,
+            "timeseries": salesTimeSeries(res.Transactions),
+            "cleaning": res.Report,
         }
 
-        c.JSON(http.StatusOK, resp)
+        return resp, nil
     }
 }
 
 // -------------------- File reading helpers --------------------
 
-func readAllRows(content []byte, ext string) ([][]string, error) {
+// readBestSheet returns the rows of a CSV, or of the workbook sheet that
+// looks most like a sales table.
+func readBestSheet(content []byte, ext string) (sheetData, error) {
+    sheets, err := readSheets(content, ext)
+    if err != nil {
+        return sheetData{}, err
+    }
+    best, _, err := pickSheet(sheets, "")
+    return best, err
+}
+
+// describeSheet records where the rows came from in a sales_metrics payload.
+func describeSheet(payload map[string]any, sheet sheetData) {
+    if sheet.Name != "" {
+        payload["sheet"] = sheet.Name
+    }
+    if sheet.Dialect != nil {
+        payload["csv_dialect"] = sheet.Dialect
+    }
+}
+
+// sheetData is one table read from an upload; CSVs yield a single unnamed
+// sheet along with the dialect they were read with.
+type sheetData struct {
+    Name    string
+    Rows    [][]string
+    Dialect *utils.CSVDialect
+}
+
+// readSheets parses content in the format ext names (".csv", ".xlsx" or ".xls").
+func readSheets(content []byte, ext string) ([]sheetData, error) {
     switch ext {
     case ".csv":
-        r := csv.NewReader(bytes.NewReader(content))
-        r.FieldsPerRecord = -1 // allow variable columns
-        rows, err := r.ReadAll()
+        // Delimiter, quote and encoding vary by POS system; sniff them
+        rows, dialect, err := utils.ReadCSV(content)
         if err != nil {
             return nil, err
         }
-        return rows, nil
-    case ".xlsx", ".xls":
-        f, err := excelize.OpenReader(bytes.NewReader(content))
+        return []sheetData{{Rows: rows, Dialect: &dialect}}, nil
+    case ".xls":
+        sheets, err := utils.ReadXLS(content)
         if err != nil {
             return nil, err
         }
-        sheets := f.GetSheetList()
-        if len(sheets) == 0 {
-            return [][]string{}, nil
+        out := make([]sheetData, len(sheets))
+        for i, sh := range sheets {
+            out[i] = sheetData{Name: sh.Name, Rows: sh.Rows}
         }
-        sheet := sheets[0]
-        rows := [][]string{}
-        rs, err := f.Rows(sheet)
+        return out, nil
+    case ".xlsx":
+        f, err := excelize.OpenReader(bytes.NewReader(content))
         if err != nil {
             return nil, err
         }
-        for rs.Next() {
-            r, err := rs.Columns()
+        defer f.Close()
+        out := []sheetData{}
+        for _, sheet := range f.GetSheetList() {
+            rows := [][]string{}
+            rs, err := f.Rows(sheet)
             if err != nil {
                 return nil, err
             }
-            rows = append(rows, r)
+            for rs.Next() {
+                // Raw values keep dates as Excel serials and amounts unformatted;
+                // utils.ParseDate and toNumeric handle both.
+                r, err := rs.Columns(excelize.Options{RawCellValue: true})
+                if err != nil {
+                    rs.Close()
+                    return nil, err
+                }
+                rows = append(rows, r)
+            }
+            rs.Close()
+            out = append(out, sheetData{Name: sheet, Rows: rows})
         }
-        return rows, nil
+        return out, nil
     default:
-        return nil, io.ErrUnexpectedEOF
+        // Bad input, so a job reading it is not retried
+        return nil, jobs.Permanent(fmt.Errorf("unsupported file type %q", ext))
+    }
+}
+
+// sheetCandidate is how one sheet scored as a sales table.
+type sheetCandidate struct {
+    Name         string  `json:"name"`
+    Rows         int     `json:"rows"`
+    Score        float64 `json:"score"`
+    HeaderRow    int     `json:"header_row"`
+    SalesColumn  string  `json:"sales_column,omitempty"`
+    BillColumn   string  `json:"bill_column,omitempty"`
+    NumericSales float64 `json:"numeric_sales_ratio"`
+    Chosen       bool    `json:"chosen"`
+}
+
+// scoreSheet rates rows as a sales table with the heuristic detector: found
+// sales and bill columns count most, then how many sales cells are numbers.
+func scoreSheet(rows [][]string) sheetCandidate {
+    det := ingestion.DetectHeuristic(rows)
+    cand := sheetCandidate{Rows: len(rows), HeaderRow: det.HeaderRow}
+    if len(rows) == 0 {
+        return cand
+    }
+    headers := ingestion.NormalizeHeaders(rows, det.HeaderRow)
+    cand.SalesColumn = ingestion.FindColumn(headers, det.Sales)
+    cand.BillColumn = ingestion.FindColumn(headers, det.Bill)
+    if cand.SalesColumn != "" {
+        cand.Score += 2
+        idx := -1
+        for i, h := range headers {
+            if h == cand.SalesColumn {
+                idx = i
+                break
+            }
+        }
+        seen, numeric := 0, 0
+        for _, r := range rows[det.HeaderRow+1:] {
+            if idx >= len(r) || strings.TrimSpace(r[idx]) == "" {
+                continue
+            }
+            seen++
+            if !math.IsNaN(toNumeric(r[idx])) {
+                numeric++
+            }
+            if seen >= 200 {
+                break
+            }
+        }
+        if seen > 0 {
+            cand.NumericSales = round2(float64(numeric) / float64(seen))
+        }
+        cand.Score += cand.NumericSales
+    }
+    if cand.BillColumn != "" {
+        cand.Score += 2
     }
+    if det.Date != "" {
+        cand.Score += 0.5
+    }
+    cand.Score = round2(cand.Score)
+    return cand
+}
+
+// pickSheet returns the sheet named want (case-insensitive) or, when want is
+// empty, the best-scoring one, plus every sheet's score for reporting.
+func pickSheet(sheets []sheetData, want string) (sheetData, []sheetCandidate, error) {
+    if len(sheets) == 0 {
+        return sheetData{Rows: [][]string{}}, nil, nil
+    }
+    cands := make([]sheetCandidate, len(sheets))
+    best := -1
+    for i, sh := range sheets {
+        cands[i] = scoreSheet(sh.Rows)
+        cands[i].Name = sh.Name
+        if want != "" {
+            if strings.EqualFold(strings.TrimSpace(sh.Name), strings.TrimSpace(want)) {
+                best = i
+            }
+        } else if best < 0 || cands[i].Score > cands[best].Score {
+            best = i
+        }
+    }
+    if best < 0 {
+        names := make([]string, len(sheets))
+        for i, sh := range sheets {
+            names[i] = sh.Name
+        }
+        return sheetData{}, cands, fmt.Errorf("sheet %q not found; available: %s", want, strings.Join(names, ", "))
+    }
+    cands[best].Chosen = true
+    return sheets[best], cands, nil
 }
 
 func firstNRows(rows [][]string, n int) [][]string {
@@ -242,18 +384,32 @@ func firstNRows(rows [][]string, n int) [][]string {
     return cp
 }
 
-func signatureForPreview(preview [][]string) string {
-    // hash JSON of preview rows for a quick signature
-    b, _ := json.Marshal(preview)
-    sum := sha256.Sum256(b)
-    return hex.EncodeToString(sum[:])
-}
-
 // -------------------- Header detection --------------------
 
-func detectHeaderAndColumns(cfg config.Config, preview [][]string) (int, string, string, bool, string) {
-    if cfg.GeminiAPIKey == "" {
-        return -1, "", "", false, "Gemini API key not configured"
+// salesDetector is how uploads find their columns: the org's saved mapping
+// for the header set, then the AI provider, then header keywords.
+func salesDetector(cfg config.Config, m utils.Meter, orgID int64) ingestion.Detector {
+    return ingestion.Chain(
+        ingestion.DetectorFunc(func(ctx context.Context, rows [][]string) (ingestion.Detection, error) {
+            if d, ok := matchMapping(ctx, orgID, rows); ok {
+                return d, nil
+            }
+            return ingestion.NoDetection, errors.New("no saved mapping")
+        }),
+        ingestion.DetectorFunc(func(ctx context.Context, rows [][]string) (ingestion.Detection, error) {
+            d, _, msg := detectHeaderAndColumns(ctx, cfg, m, firstNRows(rows, 5))
+            if d.HeaderRow < 0 {
+                return d, errors.New(msg)
+            }
+            return d, nil
+        }),
+        ingestion.Heuristic,
+    )
+}
+
+func detectHeaderAndColumns(ctx context.Context, cfg config.Config, m utils.Meter, preview [][]string) (ingestion.Detection, bool, string) {
+    if !cfg.AIEnabled() {
+        return ingestion.NoDetection, false, "AI provider not configured"
     }
 
     // Prepare a Pandas-like orient='split' JSON for the first 5 rows
@@ -279,55 +435,44 @@ func detectHeaderAndColumns(cfg config.Config, preview [][]string) (int, string,
         "Given the first 5 rows of a tabular file, identify:\n" +
         "1) Which row (0-based index) is most likely the header (column names).\n" +
         "2) The exact column name that represents \"Sales\" or \"Amount\".\n" +
-        "3) The exact column name that represents \"Bill\" or \"Invoice\".\n\n" +
-        "Important:\n- Return STRICT JSON only, no commentary, no markdown fences.\n- Use keys exactly: header_row_index, sales_column, bill_column.\n\n" +
+        "3) The exact column name that represents \"Bill\" or \"Invoice\".\n" +
+        "4) The exact column name that holds the transaction date or date/time, or \"\" if there is none.\n\n" +
+        "Important:\n- Return STRICT JSON only, no commentary, no markdown fences.\n- Use keys exactly: header_row_index, sales_column, bill_column, date_column.\n\n" +
         "Example format:\n{" +
-        "\"header_row_index\": 0, \"sales_column\": \"Item Net Amt\", \"bill_column\": \"Bill No\"}\n\n" +
+        "\"header_row_index\": 0, \"sales_column\": \"Item Net Amt\", \"bill_column\": \"Bill No\", \"date_column\": \"Bill Date\"}\n\n" +
         "Here are the first 5 rows (Pandas JSON with orient='split'):\n" + string(splitJSON)
 
-    ctx := context.Background()
-    client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.GeminiAPIKey))
+    client, err := newAI(ctx, cfg, m, "column_detection")
     if err != nil {
-        return -1, "", "", false, "Gemini client error"
+        return ingestion.NoDetection, false, "AI client error"
     }
     defer client.Close()
 
-    model := client.GenerativeModel(cfg.GeminiModel)
-    resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-    if err != nil || resp == nil || len(resp.Candidates) == 0 {
-        return -1, "", "", false, "Gemini generate error"
+    text, err := utils.GenerateText(ctx, client, prompt)
+    if err != nil {
+        return ingestion.NoDetection, false, "AI generate error"
     }
-    text := extractText(resp)
     if text == "" {
-        return -1, "", "", true, "Gemini returned empty"
+        return ingestion.NoDetection, true, "AI returned empty"
     }
     cleaned := stripFences(text)
     var out struct{
         HeaderRowIndex int    `json:"header_row_index"`
         SalesColumn    string `json:"sales_column"`
         BillColumn     string `json:"bill_column"`
+        DateColumn     string `json:"date_column"`
     }
     if err := json.Unmarshal([]byte(cleaned), &out); err != nil {
-        return -1, "", "", true, "Gemini JSON parse error"
-    }
-    return out.HeaderRowIndex, strings.TrimSpace(out.SalesColumn), strings.TrimSpace(out.BillColumn), true, "ok"
-}
-
-func cachedMappingOrDetect(cfg config.Config, userID int64, signature string, preview [][]string) (int, string, string, bool, string) {
-    // check cache first
-    if signature != "" {
-        ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-        defer cancel()
-        var headerRow int
-        var salesCol, billCol string
-        err := database.Pool.QueryRow(ctx, `SELECT header_row, sales_column, bill_column FROM column_mappings WHERE user_id=$1 AND signature=$2`, userID, signature).Scan(&headerRow, &salesCol, &billCol)
-        if err == nil {
-            return headerRow, salesCol, billCol, false, "cache"
-        }
-    }
-    // fallback to AI
-    hr, sc, bc, used, msg := detectHeaderAndColumns(cfg, preview)
-    return hr, sc, bc, used, msg
+        return ingestion.NoDetection, true, "AI JSON parse error"
+    }
+    return ingestion.Detection{
+        HeaderRow: out.HeaderRowIndex,
+        Sales:     strings.TrimSpace(out.SalesColumn),
+        Bill:      strings.TrimSpace(out.BillColumn),
+        Date:      strings.TrimSpace(out.DateColumn),
+        Source:    "ai",
+        Note:      "ok",
+    }, true, "ok"
 }
 
 func stripFences(s string) string {
@@ -338,319 +483,10 @@ func stripFences(s string) string {
     return strings.TrimSpace(t)
 }
 
-func extractText(resp *genai.GenerateContentResponse) string {
-    if resp == nil || len(resp.Candidates) == 0 {
-        return ""
-    }
-    var b strings.Builder
-    for _, c := range resp.Candidates {
-        if c == nil || c.Content == nil {
-            continue
-        }
-        for _, p := range c.Content.Parts {
-            if s, ok := p.(genai.Text); ok {
-                b.WriteString(string(s))
-            }
-        }
-    }
-    return b.String()
-}
-
-func heuristicDetect(rows [][]string) (int, string, string) {
-    headerIdx := -1
-    bestScore := -1.0
-    for i, r := range rows {
-        nonEmpty := 0
-        alpha := 0
-        for _, v := range r {
-            t := strings.TrimSpace(v)
-            if t == "" {
-                continue
-            }
-            nonEmpty++
-            if hasLetter(t) {
-                alpha++
-            }
-        }
-        if nonEmpty == 0 {
-            continue
-        }
-        score := float64(alpha) / float64(nonEmpty)
-        if score >= 0.5 && score > bestScore {
-            bestScore = score
-            headerIdx = i
-        }
-        if i >= 4 { // look at first ~5 rows
-            break
-        }
-    }
-    if headerIdx == -1 {
-        headerIdx = 0
-    }
-    headers := normalizeHeaders(rows, headerIdx)
-    sales := pickColumn(headers, []string{"sales", "amount", "amt", "net amt", "net amount", "total", "grand total", "invoice amount", "subtotal", "item net amt"})
-    bill := pickColumn(headers, []string{"bill", "bill no", "bill number", "invoice", "invoice no", "invoice number", "inv", "ref no", "reference", "voucher", "receipt"})
-    return headerIdx, sales, bill
-}
-
-func hasLetter(s string) bool {
-    for _, ch := range s {
-        if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') {
-            return true
-        }
-    }
-    return false
-}
-
-func normalizeHeaders(rows [][]string, headerIdx int) []string {
-    if headerIdx < 0 || headerIdx >= len(rows) {
-        return nil
-    }
-    raw := rows[headerIdx]
-    headers := make([]string, len(raw))
-    for i, v := range raw {
-        t := strings.TrimSpace(v)
-        if t == "" {
-            t = "Col" + strconv.Itoa(i)
-        }
-        headers[i] = t
-    }
-    return headers
-}
-
-func pickColumn(headers []string, keywords []string) string {
-    // exact match preferred
-    for _, k := range keywords {
-        for _, h := range headers {
-            if strings.EqualFold(h, k) {
-                return h
-            }
-        }
-    }
-    // substring fallback
-    for _, k := range keywords {
-        lk := strings.ToLower(k)
-        for _, h := range headers {
-            if strings.Contains(strings.ToLower(h), lk) {
-                return h
-            }
-        }
-    }
-    return ""
-}
-
-func findColumn(headers []string, target string) string {
-    t := strings.TrimSpace(strings.ToLower(target))
-    for _, h := range headers {
-        if strings.ToLower(strings.TrimSpace(h)) == t {
-            return h
-        }
-    }
-    for _, h := range headers {
-        if t != "" && strings.Contains(strings.ToLower(h), t) {
-            return h
-        }
-    }
-    return ""
-}
-
-func buildRecords(rows [][]string, headerIdx int, headers []string) []map[string]string {
-    out := make([]map[string]string, 0, len(rows)-headerIdx-1)
-    for i := headerIdx + 1; i < len(rows); i++ {
-        r := rows[i]
-        m := make(map[string]string, len(headers))
-        for j := 0; j < len(headers); j++ {
-            var v string
-            if j < len(r) {
-                v = strings.TrimSpace(r[j])
-            }
-            m[headers[j]] = v
-        }
-        out = append(out, m)
-    }
-    return out
-}
-
-// -------------------- Cleaning helpers --------------------
-
-var emptyBill = map[string]struct{}{
-    "": {}, "-": {}, "na": {}, "n/a": {}, "none": {}, "null": {}, "nil": {}, "nan": {}, "0": {},
-}
-
-func isEffectivelyEmptyBill(x string) bool {
-    t := strings.TrimSpace(strings.ToLower(x))
-    _, ok := emptyBill[t]
-    return ok
-}
-
-func dropBlankRows(rows []map[string]string) []map[string]string {
-    out := make([]map[string]string, 0, len(rows))
-    for _, r := range rows {
-        nonempty := false
-        for _, v := range r {
-            if strings.TrimSpace(v) != "" {
-                nonempty = true
-                break
-            }
-        }
-        if nonempty {
-            out = append(out, r)
-        }
-    }
-    return out
-}
-
-var totalRegex = regexp.MustCompile(`(?i)\b(grand\s*)?sub\s*total\b|\bgrand\s*total\b|\btotal\b`)
-
-func looksLikeTotalWord(s string) bool {
-    t := strings.TrimSpace(strings.ToLower(s))
-    if t == "" {
-        return false
-    }
-    if totalRegex.MatchString(t) {
-        return true
-    }
-    // very small fuzzy: allow one edit away from "total"
-    return editDistance(t, "total") <= 1
-}
-
-func rowIsTotalish(r map[string]string) bool {
-    for _, v := range r {
-        if looksLikeTotalWord(v) {
-            return true
-        }
-    }
-    return false
-}
-
-func dropIfSecondColumnTotalish(rows []map[string]string, headers []string) ([]map[string]string, map[int]struct{}) {
-    removed := map[int]struct{}{}
-    if len(rows) == 0 {
-        return rows, removed
-    }
-    // Identify the second column by original header order
-    if len(headers) < 2 {
-        return rows, removed
-    }
-    second := headers[1]
-
-    kept := make([]map[string]string, 0, len(rows))
-    for i, r := range rows {
-        if looksLikeTotalWord(r[second]) {
-            removed[i] = struct{}{}
-            continue
-        }
-        kept = append(kept, r)
-    }
-    return kept, removed
-}
-
-func filterSummaryRows(rows []map[string]string, billCol, salesCol string) ([]map[string]string, map[int]struct{}) {
-    removed := map[int]struct{}{}
-    kept := make([]map[string]string, 0, len(rows))
-    for i, r := range rows {
-        // rule 1: any 'total-ish' word anywhere
-        if rowIsTotalish(r) {
-            removed[i] = struct{}{}
-            continue
-        }
-        // rule 2: bill missing, sales numeric present, almost nothing else
-        billMissing := isEffectivelyEmptyBill(r[billCol])
-        salesVal := toNumeric(r[salesCol])
-        if billMissing && !math.IsNaN(salesVal) {
-            nonSalesNonEmpty := 0
-            for k, v := range r {
-                if k == salesCol {
-                    continue
-                }
-                if strings.TrimSpace(v) != "" && strings.ToLower(strings.TrimSpace(v)) != "nan" {
-                    nonSalesNonEmpty++
-                }
-            }
-            if nonSalesNonEmpty <= 1 {
-                removed[i] = struct{}{}
-                continue
-            }
-        }
-        kept = append(kept, r)
-    }
-    return kept, removed
-}
-
+// toNumeric reads s as a plain amount (decimal point, comma grouping), NaN
+// when it is not one.
 func toNumeric(s string) float64 {
-    t := strings.TrimSpace(s)
-    if t == "" {
-        return math.NaN()
-    }
-    // remove non digits except dot and minus
-    cleaned := make([]rune, 0, len(t))
-    for _, ch := range t {
-        if (ch >= '0' && ch <= '9') || ch == '.' || ch == '-' {
-            cleaned = append(cleaned, ch)
-        }
-    }
-    if len(cleaned) == 0 {
-        return math.NaN()
-    }
-    f, err := strconv.ParseFloat(string(cleaned), 64)
-    if err != nil {
-        return math.NaN()
-    }
-    return f
-}
-
-func uniqueCount(rows []map[string]string, col string) int {
-    seen := map[string]struct{}{}
-    for _, r := range rows {
-        v := strings.TrimSpace(r[col])
-        if v == "" {
-            continue
-        }
-        seen[v] = struct{}{}
-    }
-    return len(seen)
-}
-
-func editDistance(a, b string) int {
-    // simple Levenshtein distance
-    la, lb := len(a), len(b)
-    if la == 0 {
-        return lb
-    }
-    if lb == 0 {
-        return la
-    }
-    dp := make([][]int, la+1)
-    for i := range dp {
-        dp[i] = make([]int, lb+1)
-    }
-    for i := 0; i <= la; i++ {
-        dp[i][0] = i
-    }
-    for j := 0; j <= lb; j++ {
-        dp[0][j] = j
-    }
-    for i := 1; i <= la; i++ {
-        for j := 1; j <= lb; j++ {
-            cost := 0
-            if a[i-1] != b[j-1] {
-                cost = 1
-            }
-            dp[i][j] = min(
-                dp[i-1][j]+1,
-                dp[i][j-1]+1,
-                dp[i-1][j-1]+cost,
-            )
-        }
-    }
-    return dp[la][lb]
-}
-
-func min(a, b, c int) int {
-    m := a
-    if b < m { m = b }
-    if c < m { m = c }
-    return m
+    return ingestion.Amount(s, utils.NumberFormat{})
 }
 
 func round2(f float64) float64 {
@@ -659,22 +495,20 @@ func round2(f float64) float64 {
 
 // -------------------- AI summary --------------------
 
-func geminiSummary(cfg config.Config, total float64, rows, uniq int) string {
-    if cfg.GeminiAPIKey == "" { return "" }
-    ctx := context.Background()
-    client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.GeminiAPIKey))
+func geminiSummary(ctx context.Context, cfg config.Config, m utils.Meter, total float64, rows, uniq int) string {
+    if !cfg.AIEnabled() { return "" }
+    client, err := newAI(ctx, cfg, m, "analysis_summary")
     if err != nil { return "" }
     defer client.Close()
-    model := client.GenerativeModel(cfg.GeminiModel)
     prompt := "Create a short, friendly one-sentence summary for a user.\n" +
         "Facts:\n" +
         "- Total sales = " + strconv.FormatFloat(total, 'f', 2, 64) + "\n" +
         "- Bill row count = " + strconv.Itoa(rows) + "\n" +
         "- Unique bill IDs = " + strconv.Itoa(uniq) + "\n" +
         "Keep it concise and neutral (no emojis)."
-    resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-    if err != nil || resp == nil { return "" }
-    return strings.TrimSpace(extractText(resp))
+    text, err := utils.GenerateText(ctx, client, prompt)
+    if err != nil { return "" }
+    return strings.TrimSpace(text)
 }
 
 func simpleSummary(total float64, rows, uniq int) string {
@@ -3,26 +3,21 @@ package controllers
 import (
     "bytes"
     "context"
-    "crypto/sha256"
-    "encoding/hex"
     "encoding/json"
+    "errors"
     "io"
     "log"
     "net/http"
     "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v5"
     "scalingwolf-ai/backend/config"
     "scalingwolf-ai/backend/database"
     "scalingwolf-ai/backend/models"
     "scalingwolf-ai/backend/utils"
 )
 
-func hash(pw string) string {
-    h := sha256.Sum256([]byte(pw))
-    return hex.EncodeToString(h[:])
-}
-
 func RequestOTP(cfg config.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
         var req models.OTPRequest
@@ -31,17 +26,13 @@ func RequestOTP(cfg config.Config) gin.HandlerFunc {
             return
         }
 
-        jsonData, _ := json.Marshal(req)
-        resp, err := http.Post(cfg.OTPRequestURL, "application/json", bytes.NewBuffer(jsonData))
+        status, body, err := callOTPProvider(cfg.OTPRequestURL, req)
         if err != nil {
             c.JSON(http.StatusBadGateway, gin.H{"error": "otp provider error"})
             return
         }
-        defer resp.Body.Close()
-
-        body, _ := io.ReadAll(resp.Body)
-        log.Printf("OTP provider response (%d): %s", resp.StatusCode, string(body))
-        c.Data(resp.StatusCode, "application/json", body)
+        log.Printf("OTP provider response (%d): %s", status, string(body))
+        c.Data(status, "application/json", body)
     }
 }
 
@@ -53,19 +44,15 @@ func VerifyOTP(cfg config.Config) gin.HandlerFunc {
             return
         }
 
-        jsonData, _ := json.Marshal(req)
-        resp, err := http.Post(cfg.OTPVerifyURL, "application/json", bytes.NewBuffer(jsonData))
+        status, body, err := callOTPProvider(cfg.OTPVerifyURL, req)
         if err != nil {
             c.JSON(http.StatusBadGateway, gin.H{"error": "otp provider error"})
             return
         }
-        defer resp.Body.Close()
+        log.Printf("OTP verify response (%d): %s", status, string(body))
 
-        body, _ := io.ReadAll(resp.Body)
-        log.Printf("OTP verify response (%d): %s", resp.StatusCode, string(body))
-
-        if resp.StatusCode != http.StatusOK {
-            c.Data(resp.StatusCode, "application/json", body)
+        if status != http.StatusOK {
+            c.Data(status, "application/json", body)
             return
         }
 
@@ -80,6 +67,23 @@ func VerifyOTP(cfg config.Config) gin.HandlerFunc {
     }
 }
 
+// callOTPProvider posts payload as JSON to the WhatsApp OTP service and
+// returns its status code and raw body.
+func callOTPProvider(url string, payload interface{}) (int, []byte, error) {
+    jsonData, _ := json.Marshal(payload)
+    client := &http.Client{Timeout: 15 * time.Second}
+    resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+    if err != nil {
+        return 0, nil, err
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return 0, nil, err
+    }
+    return resp.StatusCode, body, nil
+}
+
 func Register(cfg config.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
         var req models.RegisterRequest
@@ -100,18 +104,32 @@ func Register(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusForbidden, gin.H{"error": "whatsapp not verified"})
             return
         }
-        // Upsert user (no business_name at registration)
+        pwHash, err := utils.HashPassword(req.Password)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+            return
+        }
+        // Create user (no business_name at registration). An existing email is
+        // never overwritten; those users go through the password reset flow.
         var id int64
         err = database.Pool.QueryRow(ctx, `INSERT INTO users(name,email,password_hash,phone,is_whatsapp_verified)
 VALUES($1,$2,$3,$4,TRUE)
-ON CONFLICT (email) DO UPDATE SET name=EXCLUDED.name, password_hash=EXCLUDED.password_hash, phone=EXCLUDED.phone
-RETURNING id`, req.Name, req.Email, hash(req.Password), req.Phone).Scan(&id)
+ON CONFLICT (email) DO NOTHING
+RETURNING id`, req.Name, req.Email, pwHash, req.Phone).Scan(&id)
+        if errors.Is(err, pgx.ErrNoRows) {
+            c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+            return
+        }
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
             return
         }
-        token, _ := utils.GenerateJWT(cfg.JWTSecret, id, 24*time.Hour)
-        c.JSON(http.StatusOK, gin.H{"token": token})
+        tokens, err := issueSession(ctx, c, cfg, id)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "session error"})
+            return
+        }
+        c.JSON(http.StatusOK, tokens)
     }
 }
 
@@ -126,13 +144,35 @@ func Login(cfg config.Config) gin.HandlerFunc {
         defer cancel()
         var id int64
         var pw string
-        err := database.Pool.QueryRow(ctx, `SELECT id, password_hash FROM users WHERE email=$1`, req.Email).Scan(&id, &pw)
-        if err != nil || pw != hash(req.Password) {
+        var disabled bool
+        err := database.Pool.QueryRow(ctx, `SELECT id, password_hash, disabled_at IS NOT NULL FROM users WHERE email=$1`, req.Email).Scan(&id, &pw, &disabled)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+            return
+        }
+        ok, needsRehash, err := utils.VerifyPassword(req.Password, pw)
+        if err != nil || !ok {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
             return
         }
-        token, _ := utils.GenerateJWT(cfg.JWTSecret, id, 24*time.Hour)
-        c.JSON(http.StatusOK, gin.H{"token": token})
+        if disabled {
+            c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+            return
+        }
+        // Transparently upgrade legacy sha256 (or weaker argon2id) hashes
+        if needsRehash {
+            if nh, err := utils.HashPassword(req.Password); err == nil {
+                if _, err := database.Pool.Exec(ctx, `UPDATE users SET password_hash=$1 WHERE id=$2 AND password_hash=$3`, nh, id, pw); err != nil {
+                    log.Printf("password rehash error for user %d: %v", id, err)
+                }
+            }
+        }
+        tokens, err := issueSession(ctx, c, cfg, id)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "session error"})
+            return
+        }
+        c.JSON(http.StatusOK, tokens)
     }
 }
 
@@ -143,11 +183,11 @@ func CompanySetup(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
             return
         }
-        uid := c.GetInt64("user_id")
+        orgID := c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
-        _, err := database.Pool.Exec(ctx, `UPDATE users SET business_name=$1, monthly_revenue=$2, employees=$3, goal_amount=$4, goal_years=$5, industry_type=$6, sub_industry=$7, core_processes=$8 WHERE id=$9`,
-            req.BusinessName, req.MonthlyRevenue, req.Employees, req.GoalAmount, req.GoalYears, req.IndustryType, req.SubIndustry, req.CoreProcesses, uid)
+        _, err := database.Pool.Exec(ctx, `UPDATE organizations SET name=$1, monthly_revenue=$2, employees=$3, goal_amount=$4, goal_years=$5, industry_type=$6, sub_industry=$7, core_processes=$8 WHERE id=$9`,
+            req.BusinessName, req.MonthlyRevenue, req.Employees, req.GoalAmount, req.GoalYears, req.IndustryType, req.SubIndustry, req.CoreProcesses, orgID)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
             return
@@ -155,3 +195,262 @@ func CompanySetup(cfg config.Config) gin.HandlerFunc {
         c.JSON(http.StatusOK, gin.H{"status": "ok"})
     }
 }
+
+// issueSession starts a server-side session for uid and returns a short-lived
+// access token plus the refresh token that rotates it. "token" mirrors
+// access_token for older clients.
+func issueSession(ctx context.Context, c *gin.Context, cfg config.Config, uid int64) (gin.H, error) {
+    orgID, err := activeOrg(ctx, uid)
+    if err != nil {
+        return nil, err
+    }
+    refresh, refreshHash, err := utils.NewOpaqueToken()
+    if err != nil {
+        return nil, err
+    }
+    var sid int64
+    err = database.Pool.QueryRow(ctx, `INSERT INTO sessions(user_id, org_id, refresh_token_hash, user_agent, ip, expires_at)
+VALUES($1,$2,$3,$4,$5,$6) RETURNING id`, uid, orgID, refreshHash, c.Request.UserAgent(), c.ClientIP(), time.Now().Add(cfg.RefreshTokenTTL)).Scan(&sid)
+    if err != nil {
+        return nil, err
+    }
+    return sessionTokens(cfg, uid, sid, orgID, refresh)
+}
+
+// sessionTokens signs an access token for the session's active org. refresh
+// is left out of the response when empty (org switch keeps the old one).
+func sessionTokens(cfg config.Config, uid, sid, orgID int64, refresh string) (gin.H, error) {
+    access, err := utils.GenerateJWT(cfg.JWTSecret, utils.Claims{UserID: uid, SessionID: sid, OrgID: orgID}, cfg.AccessTokenTTL)
+    if err != nil {
+        return nil, err
+    }
+    out := gin.H{
+        "token":        access,
+        "access_token": access,
+        "token_type":   "Bearer",
+        "expires_in":   int64(cfg.AccessTokenTTL / time.Second),
+        "org_id":       orgID,
+    }
+    if refresh != "" {
+        out["refresh_token"] = refresh
+    }
+    return out, nil
+}
+
+// RefreshToken exchanges a valid refresh token for a new access token and a
+// new refresh token. Presenting an already-rotated token revokes the session,
+// since it means the token was copied.
+func RefreshToken(cfg config.Config) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.RefreshRequest
+        if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        oldHash := utils.HashToken(req.RefreshToken)
+        refresh, newHash, err := utils.NewOpaqueToken()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+            return
+        }
+        var sid, uid, orgID int64
+        err = database.Pool.QueryRow(ctx, `UPDATE sessions
+SET previous_token_hash=refresh_token_hash, refresh_token_hash=$2, last_used_at=now()
+WHERE refresh_token_hash=$1 AND revoked_at IS NULL AND expires_at > now()
+RETURNING id, user_id, COALESCE(org_id, 0)`, oldHash, newHash).Scan(&sid, &uid, &orgID)
+        if err != nil {
+            var reused int64
+            if database.Pool.QueryRow(ctx, `UPDATE sessions SET revoked_at=now() WHERE previous_token_hash=$1 AND revoked_at IS NULL RETURNING id`, oldHash).Scan(&reused) == nil {
+                log.Printf("refresh token reuse detected; revoked session %d", reused)
+            }
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+            return
+        }
+        // Fall back to another org if the user left the session's one.
+        orgID, err = sessionOrg(ctx, sid, uid, orgID)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        tokens, err := sessionTokens(cfg, uid, sid, orgID, refresh)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+            return
+        }
+        c.JSON(http.StatusOK, tokens)
+    }
+}
+
+// Logout revokes the session behind the current access token.
+func Logout() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        _, err := database.Pool.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, c.GetInt64("session_id"), c.GetInt64("user_id"))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "logged out"})
+    }
+}
+
+// LogoutAll revokes every active session of the current user.
+func LogoutAll() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        res, err := database.Pool.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, c.GetInt64("user_id"))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "logged out", "sessions_revoked": res.RowsAffected()})
+    }
+}
+
+const passwordResetTTL = 15 * time.Minute
+
+// resetAccount finds the user a reset request refers to, by email or phone.
+func resetAccount(ctx context.Context, email, phone string) (int64, string, error) {
+    var id int64
+    var userPhone string
+    var err error
+    if email != "" {
+        err = database.Pool.QueryRow(ctx, `SELECT id, COALESCE(phone,'') FROM users WHERE email=$1`, email).Scan(&id, &userPhone)
+    } else {
+        err = database.Pool.QueryRow(ctx, `SELECT id, COALESCE(phone,'') FROM users WHERE phone=$1 ORDER BY id LIMIT 1`, phone).Scan(&id, &userPhone)
+    }
+    if err == nil && userPhone == "" {
+        err = pgx.ErrNoRows
+    }
+    return id, userPhone, err
+}
+
+// ForgotPassword sends a WhatsApp OTP to the phone on the account. The
+// response is the same whether or not the account exists.
+func ForgotPassword(cfg config.Config) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.ForgotPasswordRequest
+        if err := c.ShouldBindJSON(&req); err != nil || (req.Email == "" && req.Phone == "") {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "email or phone_number required"})
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+        defer cancel()
+        id, phone, err := resetAccount(ctx, req.Email, req.Phone)
+        if err == nil {
+            status, body, err := callOTPProvider(cfg.OTPRequestURL, models.OTPRequest{Phone: phone})
+            if err != nil {
+                c.JSON(http.StatusBadGateway, gin.H{"error": "otp provider error"})
+                return
+            }
+            log.Printf("reset OTP provider response for user %d (%d): %s", id, status, string(body))
+            if status >= 500 {
+                c.JSON(http.StatusBadGateway, gin.H{"error": "otp provider error"})
+                return
+            }
+        } else if !errors.Is(err, pgx.ErrNoRows) {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "if the account exists, an OTP was sent to its WhatsApp number"})
+    }
+}
+
+// VerifyResetOTP checks the OTP with the provider and hands out a single-use
+// reset token valid for passwordResetTTL.
+func VerifyResetOTP(cfg config.Config) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.ResetVerifyRequest
+        if err := c.ShouldBindJSON(&req); err != nil || req.OTP == "" || (req.Email == "" && req.Phone == "") {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+        defer cancel()
+        id, phone, err := resetAccount(ctx, req.Email, req.Phone)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid otp"})
+            return
+        }
+        status, body, err := callOTPProvider(cfg.OTPVerifyURL, models.OTPVerifyRequest{Phone: phone, OTP: req.OTP})
+        if err != nil {
+            c.JSON(http.StatusBadGateway, gin.H{"error": "otp provider error"})
+            return
+        }
+        log.Printf("reset OTP verify response for user %d (%d): %s", id, status, string(body))
+        if status != http.StatusOK {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid otp"})
+            return
+        }
+        token, tokenHash, err := utils.NewOpaqueToken()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+            return
+        }
+        // A new token supersedes any unused one for the same user.
+        if _, err := database.Pool.Exec(ctx, `UPDATE password_resets SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, id); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        _, err = database.Pool.Exec(ctx, `INSERT INTO password_resets(user_id, token_hash, expires_at) VALUES($1,$2,$3)`, id, tokenHash, time.Now().Add(passwordResetTTL))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"reset_token": token, "expires_in": int64(passwordResetTTL / time.Second)})
+    }
+}
+
+// ResetPassword consumes a reset token, stores the new password and signs the
+// user out everywhere.
+func ResetPassword() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.ResetPasswordRequest
+        if err := c.ShouldBindJSON(&req); err != nil || req.ResetToken == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+            return
+        }
+        if req.Password == "" || req.Password != req.Confirm {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "password mismatch"})
+            return
+        }
+        pwHash, err := utils.HashPassword(req.Password)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        tx, err := database.Pool.Begin(ctx)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        defer tx.Rollback(ctx)
+        var uid int64
+        err = tx.QueryRow(ctx, `UPDATE password_resets SET used_at=now()
+WHERE token_hash=$1 AND used_at IS NULL AND expires_at > now()
+RETURNING user_id`, utils.HashToken(req.ResetToken)).Scan(&uid)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired reset token"})
+            return
+        }
+        if _, err := tx.Exec(ctx, `UPDATE users SET password_hash=$1 WHERE id=$2`, pwHash, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        if err := tx.Commit(ctx); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "password updated"})
+    }
+}
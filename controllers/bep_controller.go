@@ -2,6 +2,7 @@ package controllers
 
 import (
     "context"
+    "encoding/json"
     "math"
     "net/http"
     "time"
@@ -9,6 +10,7 @@ import (
     "github.com/gin-gonic/gin"
     "scalingwolf-ai/backend/config"
     "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/ingestion"
 )
 
 type CalcBEPRequest struct {
@@ -21,8 +23,29 @@ type CalcBEPRequest struct {
     // Optional overrides for metrics; otherwise latest metrics are used
     TotalSalesOverride   *float64 `json:"total_sales,omitempty"`
     BillRowCountOverride *int     `json:"bill_row_count,omitempty"`
+
+    // Which revenue of the latest metrics to use: total (plain sum, default),
+    // gross (sales only) or net (sales minus returns). Gross and net count
+    // only sale rows as bills.
+    RevenueBasis string `json:"revenue_basis,omitempty"`
+    // What average revenue per bill divides by: rows (bill lines, default)
+    // or unique (distinct bills, i.e. average order value). Ignored with
+    // overrides.
+    BillBasis string `json:"bill_basis,omitempty"`
 }
 
+// Revenue bases for CalcBEP; basisOverride is recorded when the request
+// brings its own figures.
+const (
+    basisTotal    = "total"
+    basisGross    = "gross"
+    basisNet      = "net"
+    basisOverride = "override"
+
+    billBasisRows   = "rows"
+    billBasisUnique = "unique"
+)
+
 func CalcBEP(cfg config.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
         var req CalcBEPRequest
@@ -30,7 +53,23 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body or fixed_cost"})
             return
         }
-        uid := c.GetInt64("user_id")
+        basis := req.RevenueBasis
+        if basis == "" {
+            basis = basisTotal
+        }
+        if basis != basisTotal && basis != basisGross && basis != basisNet {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "revenue_basis must be total, gross or net"})
+            return
+        }
+        billBasis := req.BillBasis
+        if billBasis == "" {
+            billBasis = billBasisRows
+        }
+        if billBasis != billBasisRows && billBasis != billBasisUnique {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "bill_basis must be rows or unique"})
+            return
+        }
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
 
         // Resolve metrics: from overrides or latest sales_metrics
         var totalSales float64
@@ -39,16 +78,18 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
         if req.TotalSalesOverride != nil && req.BillRowCountOverride != nil {
             totalSales = *req.TotalSalesOverride
             billRows = *req.BillRowCountOverride
+            basis, billBasis = basisOverride, billBasisRows
         } else {
             ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
             defer cancel()
             var id int64
             var ts *float64
-            var br *int
+            var br, ub *int
+            var revenueText string
             err := database.Pool.QueryRow(ctx,
-                `SELECT id, total_sales::float8, bill_row_count::int FROM sales_metrics WHERE user_id=$1 AND total_sales IS NOT NULL AND bill_row_count IS NOT NULL AND bill_row_count > 0 ORDER BY created_at DESC LIMIT 1`,
-                uid,
-            ).Scan(&id, &ts, &br)
+                `SELECT id, total_sales::float8, bill_row_count::int, unique_bill_count::int, COALESCE(payload->'revenue','null')::text FROM sales_metrics WHERE org_id=$1 AND total_sales IS NOT NULL AND bill_row_count IS NOT NULL AND bill_row_count > 0 ORDER BY created_at DESC LIMIT 1`,
+                orgID,
+            ).Scan(&id, &ts, &br, &ub, &revenueText)
             if err != nil || ts == nil || br == nil || *br == 0 {
                 c.JSON(http.StatusBadRequest, gin.H{"error": "no sales metrics found; upload a file or provide overrides"})
                 return
@@ -56,9 +97,31 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
             totalSales = *ts
             billRows = *br
             sourceMetricsID = &id
+            if billBasis == billBasisUnique {
+                if ub == nil {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "latest sales metrics have no unique bill count; use bill_basis rows"})
+                    return
+                }
+                billRows = *ub
+            }
+            if basis != basisTotal {
+                var rev *ingestion.Revenue
+                _ = json.Unmarshal([]byte(revenueText), &rev)
+                if rev == nil {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "latest sales metrics have no returns breakdown; upload the file again or use revenue_basis total"})
+                    return
+                }
+                totalSales, billRows = rev.NetSales, rev.SaleRows
+                if billBasis == billBasisUnique {
+                    billRows = rev.UniqueSaleBills
+                }
+                if basis == basisGross {
+                    totalSales = rev.GrossSales
+                }
+            }
         }
         if billRows <= 0 {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "bill_row_count must be > 0"})
+            c.JSON(http.StatusBadRequest, gin.H{"error": "bill count must be > 0"})
             return
         }
         avgRevenue := totalSales / float64(billRows)
@@ -93,9 +156,9 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
         var srcID any
         if sourceMetricsID != nil { srcID = *sourceMetricsID } else { srcID = nil }
         _, err := database.Pool.Exec(ctx, `
-            INSERT INTO bep_results(user_id, source_metrics_id, fixed_cost, variable_cost_rate, variable_cost_per_bill, gross_margin_rate, avg_revenue_per_bill, contribution_per_bill, bep_bills, bep_sales)
-            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
-        `, uid, srcID, req.FixedCost, req.VariableCostRate, req.VariableCostPerBill, req.GrossMarginRate, avgRevenue, contrib, bepBills, bepSales)
+            INSERT INTO bep_results(user_id, org_id, source_metrics_id, fixed_cost, variable_cost_rate, variable_cost_per_bill, gross_margin_rate, avg_revenue_per_bill, contribution_per_bill, bep_bills, bep_sales, revenue_basis, bill_basis)
+            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+        `, uid, orgID, srcID, req.FixedCost, req.VariableCostRate, req.VariableCostPerBill, req.GrossMarginRate, avgRevenue, contrib, bepBills, bepSales, basis, billBasis)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert error"})
             return
@@ -103,7 +166,7 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
 
         c.JSON(http.StatusOK, gin.H{
             "bep": gin.H{"bills": bepBills, "sales": bepSales},
-            "metrics_used": gin.H{"total_sales": totalSales, "bill_row_count": billRows, "source_metrics_id": sourceMetricsID},
+            "metrics_used": gin.H{"total_sales": totalSales, "bill_row_count": billRows, "source_metrics_id": sourceMetricsID, "revenue_basis": basis, "bill_basis": billBasis},
             "derived": gin.H{"avg_revenue_per_bill": avgRevenue, "contribution_per_bill": contrib},
         })
     }
@@ -111,7 +174,7 @@ func CalcBEP(cfg config.Config) gin.HandlerFunc {
 
 func GetLatestBEP() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
+        orgID := c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
         var (
@@ -123,12 +186,14 @@ func GetLatestBEP() gin.HandlerFunc {
             vRate *float64
             vPerBill *float64
             gRate *float64
+            basis string
+            billBasis string
             created time.Time
         )
         err := database.Pool.QueryRow(ctx, `
-            SELECT bep_bills::int, bep_sales::float8, avg_revenue_per_bill::float8, contribution_per_bill::float8, fixed_cost::float8, variable_cost_rate::float8, variable_cost_per_bill::float8, gross_margin_rate::float8, created_at
-            FROM bep_results WHERE user_id=$1 ORDER BY created_at DESC LIMIT 1`, uid,
-        ).Scan(&bepBills, &bepSales, &avgRev, &contrib, &fixed, &vRate, &vPerBill, &gRate, &created)
+            SELECT bep_bills::int, bep_sales::float8, avg_revenue_per_bill::float8, contribution_per_bill::float8, fixed_cost::float8, variable_cost_rate::float8, variable_cost_per_bill::float8, gross_margin_rate::float8, revenue_basis, bill_basis, created_at
+            FROM bep_results WHERE org_id=$1 ORDER BY created_at DESC LIMIT 1`, orgID,
+        ).Scan(&bepBills, &bepSales, &avgRev, &contrib, &fixed, &vRate, &vPerBill, &gRate, &basis, &billBasis, &created)
         if err != nil {
             c.JSON(http.StatusNotFound, gin.H{"error": "no bep results"})
             return
@@ -136,7 +201,7 @@ func GetLatestBEP() gin.HandlerFunc {
         c.JSON(http.StatusOK, gin.H{
             "bep": gin.H{"bills": bepBills, "sales": bepSales},
             "derived": gin.H{"avg_revenue_per_bill": avgRev, "contribution_per_bill": contrib},
-            "inputs": gin.H{"fixed_cost": fixed, "variable_cost_rate": vRate, "variable_cost_per_bill": vPerBill, "gross_margin_rate": gRate},
+            "inputs": gin.H{"fixed_cost": fixed, "variable_cost_rate": vRate, "variable_cost_per_bill": vPerBill, "gross_margin_rate": gRate, "revenue_basis": basis, "bill_basis": billBasis},
             "created_at": created,
         })
     }
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+
+    "scalingwolf-ai/backend/utils"
+)
+
+// sseEvent is one event read back from a recorded stream.
+type sseEvent struct {
+    name string
+    data string
+}
+
+func readEvents(body string) []sseEvent {
+    var out []sseEvent
+    for _, block := range strings.Split(body, "\n\n") {
+        var e sseEvent
+        for _, line := range strings.Split(block, "\n") {
+            if v, ok := strings.CutPrefix(line, "event:"); ok {
+                e.name = v
+            } else if v, ok := strings.CutPrefix(line, "data:"); ok {
+                e.data = v
+            }
+        }
+        if e.name != "" {
+            out = append(out, e)
+        }
+    }
+    return out
+}
+
+func streamTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+    gin.SetMode(gin.TestMode)
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    c.Request = httptest.NewRequest(http.MethodPost, "/api/chat/send/stream", nil)
+    return c, w
+}
+
+func TestStreamChatTurnEvents(t *testing.T) {
+    c, w := streamTestContext()
+    turn := &chatTurn{
+        chatID:     7,
+        ai:         utils.Metered(utils.NewFakeProvider(), &settleMeter{}, "chat"),
+        parts:      []string{"You are a business consultant.", "User: how are sales"},
+        retrieved:  []string{"Sales metrics summary"},
+        ingestions: []IngestionResult{{Type: "job", FileName: "sales.csv", Status: "queued"}},
+    }
+    var persisted string
+    var eventsBeforeFinish []sseEvent
+    streamChatTurn(context.Background(), c, turn, func(reply string, usage utils.Usage) (*chatTokens, error) {
+        persisted = reply
+        eventsBeforeFinish = readEvents(w.Body.String())
+        return &chatTokens{Input: usage.PromptTokens, Output: usage.OutputTokens, Total: usage.TotalTokens}, nil
+    })
+
+    if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+        t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+    }
+    const want = "fake reply (User: how are sales)"
+    events := readEvents(w.Body.String())
+    var names []string
+    var text strings.Builder
+    for _, e := range events {
+        names = append(names, e.name)
+        if e.name == "delta" {
+            var d struct{ Text string }
+            if err := json.Unmarshal([]byte(e.data), &d); err != nil {
+                t.Fatalf("delta data %q: %v", e.data, err)
+            }
+            text.WriteString(d.Text)
+        }
+    }
+    wantNames := []string{"chat", "ingestions", "docs", "delta", "delta", "delta", "delta", "delta", "delta", "usage", "done"}
+    if strings.Join(names, ",") != strings.Join(wantNames, ",") {
+        t.Fatalf("events = %v, want %v", names, wantNames)
+    }
+    if text.String() != want {
+        t.Errorf("deltas joined = %q, want %q", text.String(), want)
+    }
+
+    // The whole reply is persisted once, after the last delta and before usage
+    if persisted != want {
+        t.Errorf("persisted reply = %q, want %q", persisted, want)
+    }
+    if n := len(eventsBeforeFinish); n == 0 || eventsBeforeFinish[n-1].name != "delta" {
+        t.Errorf("events before persisting = %v, want the deltas to be done", eventsBeforeFinish)
+    }
+    var done struct {
+        ChatID int64  `json:"chat_id"`
+        Reply  string `json:"reply"`
+    }
+    if err := json.Unmarshal([]byte(events[len(events)-1].data), &done); err != nil || done.ChatID != 7 || done.Reply != want {
+        t.Errorf("done = %+v, %v", done, err)
+    }
+}
+
+// refusingMeter turns every reservation down with err.
+type refusingMeter struct{ err error }
+
+func (m refusingMeter) Reserve(ctx context.Context, feature string, tokens int64) (utils.Reservation, error) {
+    return nil, m.err
+}
+
+func TestStreamChatTurnQuotaBeforeStream(t *testing.T) {
+    cases := []struct {
+        err    *quotaError
+        status int
+        code   string
+    }{
+        {&quotaError{quota: 1000, used: 990, requested: 2100}, http.StatusPaymentRequired, "quota_exceeded"},
+        {&quotaError{busy: true, quota: 1000, used: 10}, http.StatusTooManyRequests, "too_many_requests"},
+    }
+    for _, tc := range cases {
+        c, w := streamTestContext()
+        turn := &chatTurn{chatID: 7, ai: utils.Metered(utils.NewFakeProvider(), refusingMeter{tc.err}, "chat"), parts: []string{"User: hi"}}
+        finished := false
+        streamChatTurn(context.Background(), c, turn, func(string, utils.Usage) (*chatTokens, error) {
+            finished = true
+            return nil, nil
+        })
+
+        if w.Code != tc.status || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+            t.Errorf("%s: status %d, Content-Type %q; want %d JSON", tc.code, w.Code, w.Header().Get("Content-Type"), tc.status)
+        }
+        var body struct{ Code string }
+        if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tc.code {
+            t.Errorf("%s: body %q is not the quota error", tc.code, w.Body.String())
+        }
+        if finished {
+            t.Errorf("%s: a refused turn was persisted", tc.code)
+        }
+    }
+}
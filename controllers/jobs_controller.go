@@ -0,0 +1,64 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/jobs"
+)
+
+// Job kinds handled by this package.
+const (
+    jobUploadAnalyze = "upload_analyze"
+    jobChatIngest    = "chat_ingest"
+)
+
+// RegisterJobs installs the background job handlers; call before jobs.Start.
+func RegisterJobs(cfg config.Config) {
+    jobs.Register(jobUploadAnalyze, runUploadAnalyze(cfg))
+    jobs.Register(jobChatIngest, runChatIngest(cfg))
+}
+
+type JobStatus struct {
+    ID           int64           `json:"id"`
+    Kind         string          `json:"kind"`
+    Status       string          `json:"status"`
+    Progress     int             `json:"progress"`
+    ProgressNote string          `json:"progress_note,omitempty"`
+    Attempts     int             `json:"attempts"`
+    MaxAttempts  int             `json:"max_attempts"`
+    Error        *string         `json:"error,omitempty"`
+    Result       json.RawMessage `json:"result,omitempty"`
+    NextRunAt    *time.Time      `json:"next_run_at,omitempty"`
+    CreatedAt    time.Time       `json:"created_at"`
+    UpdatedAt    time.Time       `json:"updated_at"`
+    FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+}
+
+// GetJob returns the status, progress and (once finished) result or error
+// of one of the caller's jobs in the active organization.
+func GetJob() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+        if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid id"}); return }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        var j JobStatus
+        var result *string
+        var runAt time.Time
+        err = database.Pool.QueryRow(ctx, `SELECT id, kind, status, progress, progress_note, attempts, max_attempts, error, result::text, run_at, created_at, updated_at, finished_at
+            FROM jobs WHERE id=$1 AND user_id=$2 AND org_id=$3`, id, c.GetInt64("user_id"), c.GetInt64("org_id")).
+            Scan(&j.ID, &j.Kind, &j.Status, &j.Progress, &j.ProgressNote, &j.Attempts, &j.MaxAttempts, &j.Error, &result, &runAt, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"job not found"}); return }
+        if result != nil { j.Result = json.RawMessage(*result) }
+        // A queued job with attempts behind it is waiting out its retry backoff
+        if j.Status == jobs.StatusQueued && j.Attempts > 0 { j.NextRunAt = &runAt }
+        c.JSON(http.StatusOK, j)
+    }
+}
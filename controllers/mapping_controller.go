@@ -0,0 +1,356 @@
+package controllers
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v5"
+    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/ingestion"
+)
+
+// mappingScanRows is how many top rows are tried as the header row when
+// matching saved mappings.
+const mappingScanRows = 10
+
+// ColumnMapping is a saved header row/column choice for one data source,
+// matched to uploads by the set of its header names.
+type ColumnMapping struct {
+    ID          int64    `json:"id"`
+    Name        string   `json:"name"`
+    Headers     []string `json:"headers"` // empty for mappings saved before header matching
+    HeaderRow   int      `json:"header_row"`
+    SalesColumn string   `json:"sales_column"`
+    BillColumn  string   `json:"bill_column"`
+    DateColumn  string   `json:"date_column"`
+    // Rules are the cleaning rules for uploads this mapping matches.
+    Rules     ingestion.Rules `json:"rules"`
+    CreatedAt time.Time       `json:"created_at"`
+    UpdatedAt time.Time       `json:"updated_at"`
+}
+
+const mappingColumns = `id, name, COALESCE(headers, '[]'::jsonb), header_row, sales_column, bill_column, COALESCE(date_column,''), COALESCE(rules::text,''), created_at, updated_at`
+
+func scanMapping(row pgx.Row, m *ColumnMapping) error {
+    var rules string
+    if err := row.Scan(&m.ID, &m.Name, &m.Headers, &m.HeaderRow, &m.SalesColumn, &m.BillColumn, &m.DateColumn, &rules, &m.CreatedAt, &m.UpdatedAt); err != nil {
+        return err
+    }
+    m.Rules = decodeRules(rules)
+    return nil
+}
+
+// decodeRules reads rules saved as JSON over the defaults; "" (a mapping
+// without rules) gives the defaults.
+func decodeRules(text string) ingestion.Rules {
+    r := ingestion.DefaultRules()
+    if text != "" {
+        _ = json.Unmarshal([]byte(text), &r)
+    }
+    return r
+}
+
+// checkRules validates r and spells its column names as in headers.
+func checkRules(r *ingestion.Rules, headers []string) string {
+    if err := r.Validate(); err != nil {
+        return err.Error()
+    }
+    for i, col := range r.SummaryIgnoreColumns {
+        h := exactHeader(headers, col)
+        if h == "" {
+            return "summary_ignore_columns: " + col + " not found in headers"
+        }
+        r.SummaryIgnoreColumns[i] = h
+    }
+    for i, x := range r.Exclusions {
+        h := exactHeader(headers, x.Column)
+        if h == "" {
+            return "exclusions: " + x.Column + " not found in headers"
+        }
+        r.Exclusions[i].Column = h
+    }
+    return ""
+}
+
+// headerKey identifies a header row by its set of names: case, spacing,
+// column order and blank cells do not matter. It is "" for rows with fewer
+// than two named cells, which cannot be told apart from data.
+func headerKey(row []string) string {
+    seen := map[string]struct{}{}
+    names := make([]string, 0, len(row))
+    for _, v := range row {
+        n := strings.ToLower(strings.Join(strings.Fields(v), " "))
+        if n == "" {
+            continue
+        }
+        if _, ok := seen[n]; !ok {
+            seen[n] = struct{}{}
+            names = append(names, n)
+        }
+    }
+    if len(names) < 2 {
+        return ""
+    }
+    sort.Strings(names)
+    sum := sha256.Sum256([]byte(strings.Join(names, "\x1f")))
+    return hex.EncodeToString(sum[:])
+}
+
+// matchMapping looks for a saved mapping whose header set equals one of the
+// top rows. The header row is where the match was found, so extra title
+// lines above the header do not break it.
+func matchMapping(ctx context.Context, orgID int64, rows [][]string) (ingestion.Detection, bool) {
+    keys := []string{}
+    at := map[string]int{}
+    for i, r := range firstNRows(rows, mappingScanRows) {
+        if k := headerKey(r); k != "" {
+            if _, dup := at[k]; !dup {
+                at[k] = i
+                keys = append(keys, k)
+            }
+        }
+    }
+    if len(keys) == 0 {
+        return ingestion.NoDetection, false
+    }
+    dbRows, err := database.Pool.Query(ctx, `SELECT header_key, sales_column, bill_column, COALESCE(date_column,''), COALESCE(rules::text,'') FROM column_mappings WHERE org_id=$1 AND header_key = ANY($2::text[])`, orgID, keys)
+    if err != nil {
+        return ingestion.NoDetection, false
+    }
+    defer dbRows.Close()
+    best := ingestion.NoDetection
+    for dbRows.Next() {
+        var key, rules string
+        d := ingestion.Detection{Source: "cache"}
+        if err := dbRows.Scan(&key, &d.Sales, &d.Bill, &d.Date, &rules); err != nil {
+            continue
+        }
+        if rules != "" {
+            r := decodeRules(rules)
+            d.Rules = &r
+        }
+        d.HeaderRow = at[key]
+        if best.HeaderRow < 0 || d.HeaderRow < best.HeaderRow {
+            best = d
+        }
+    }
+    return best, best.HeaderRow >= 0
+}
+
+// mappingRules returns the cleaning rules saved for the header set of row,
+// if a mapping for it has any.
+func mappingRules(ctx context.Context, orgID int64, row []string) (ingestion.Rules, bool) {
+    var rules string
+    err := database.Pool.QueryRow(ctx, `SELECT COALESCE(rules::text,'') FROM column_mappings WHERE org_id=$1 AND header_key=$2`, orgID, headerKey(row)).Scan(&rules)
+    if err != nil || rules == "" {
+        return ingestion.Rules{}, false
+    }
+    return decodeRules(rules), true
+}
+
+// saveColumnMapping stores m for the header set at rows[m.HeaderRow],
+// replacing the org's previous mapping for that set. An empty name keeps
+// the existing one, and so do nil rules.
+func saveColumnMapping(ctx context.Context, uid, orgID int64, rows [][]string, m confirmedMapping) error {
+    if m.HeaderRow < 0 || m.HeaderRow >= len(rows) {
+        return errors.New("header row out of range")
+    }
+    key := headerKey(rows[m.HeaderRow])
+    if key == "" {
+        return errors.New("header row has too few names to match on")
+    }
+    headers, _ := json.Marshal(ingestion.NormalizeHeaders(rows, m.HeaderRow))
+    _, err := database.Pool.Exec(ctx, `INSERT INTO column_mappings(user_id, org_id, header_key, headers, name, header_row, sales_column, bill_column, date_column, rules)
+        VALUES($1,$2,$3,$4::jsonb,$5,$6,$7,$8,NULLIF($9,''),$10::jsonb)
+        ON CONFLICT (org_id, header_key) DO UPDATE SET header_row=EXCLUDED.header_row, sales_column=EXCLUDED.sales_column, bill_column=EXCLUDED.bill_column,
+            date_column=EXCLUDED.date_column, headers=EXCLUDED.headers, name=COALESCE(NULLIF(EXCLUDED.name,''), column_mappings.name),
+            rules=COALESCE(EXCLUDED.rules, column_mappings.rules), updated_at=now()`,
+        uid, orgID, key, string(headers), strings.TrimSpace(m.Name), m.HeaderRow, m.Sales, m.Bill, m.Date, rulesJSON(m.Rules))
+    return err
+}
+
+// rulesJSON is r as a jsonb parameter, NULL when r is nil.
+func rulesJSON(r *ingestion.Rules) *string {
+    if r == nil {
+        return nil
+    }
+    b, _ := json.Marshal(r)
+    s := string(b)
+    return &s
+}
+
+// ListColumnMappings returns the active org's saved mappings, newest first.
+func ListColumnMappings() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rows, err := database.Pool.Query(ctx, `SELECT `+mappingColumns+` FROM column_mappings WHERE org_id=$1 ORDER BY updated_at DESC, id DESC`, c.GetInt64("org_id"))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []ColumnMapping{}
+        for rows.Next() {
+            var m ColumnMapping
+            if err := scanMapping(rows, &m); err != nil { continue }
+            out = append(out, m)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out})
+    }
+}
+
+func GetColumnMapping() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+        if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid id"}); return }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        var m ColumnMapping
+        err = scanMapping(database.Pool.QueryRow(ctx, `SELECT `+mappingColumns+` FROM column_mappings WHERE id=$1 AND org_id=$2`, id, c.GetInt64("org_id")), &m)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"mapping not found"}); return }
+        c.JSON(http.StatusOK, m)
+    }
+}
+
+type mappingRequest struct {
+    Name        *string  `json:"name"`
+    Headers     []string `json:"headers"`
+    SalesColumn *string  `json:"sales_column"`
+    BillColumn  *string  `json:"bill_column"`
+    DateColumn  *string  `json:"date_column"`
+    // Rules are decoded over the mapping's current rules, so a partial
+    // object changes only the fields it names.
+    Rules json.RawMessage `json:"rules"`
+}
+
+// apply copies the fields set in req onto m, checking that the columns are
+// among m.Headers (spelled as there) and that sales and bill differ. It
+// reports whether rules were given.
+func (req mappingRequest) apply(m *ColumnMapping) (string, bool) {
+    if req.Name != nil {
+        m.Name = strings.TrimSpace(*req.Name)
+    }
+    for _, f := range []struct {
+        field string
+        in    *string
+        out   *string
+    }{
+        {"sales_column", req.SalesColumn, &m.SalesColumn},
+        {"bill_column", req.BillColumn, &m.BillColumn},
+        {"date_column", req.DateColumn, &m.DateColumn},
+    } {
+        if f.in == nil {
+            continue
+        }
+        if strings.TrimSpace(*f.in) == "" && f.field == "date_column" {
+            *f.out = ""
+            continue
+        }
+        h := exactHeader(m.Headers, *f.in)
+        if h == "" {
+            return f.field + " not found in headers", false
+        }
+        *f.out = h
+    }
+    if m.SalesColumn == "" || m.BillColumn == "" {
+        return "sales_column and bill_column required", false
+    }
+    if m.SalesColumn == m.BillColumn {
+        return "sales_column and bill_column must differ", false
+    }
+    if len(req.Rules) == 0 || string(req.Rules) == "null" {
+        return "", false
+    }
+    r := m.Rules
+    if err := json.Unmarshal(req.Rules, &r); err != nil {
+        return "invalid rules", false
+    }
+    if msg := checkRules(&r, m.Headers); msg != "" {
+        return msg, false
+    }
+    m.Rules = r
+    return "", true
+}
+
+// CreateColumnMapping saves a mapping for a header set given up front, for
+// data sources whose layout is known before the first upload.
+func CreateColumnMapping() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req mappingRequest
+        if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid body"}); return }
+        m := ColumnMapping{Headers: make([]string, 0, len(req.Headers)), Rules: ingestion.DefaultRules()}
+        for _, h := range req.Headers {
+            if h = strings.TrimSpace(h); h != "" {
+                m.Headers = append(m.Headers, h)
+            }
+        }
+        key := headerKey(m.Headers)
+        if key == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"headers must name at least two columns"}); return }
+        msg, hasRules := req.apply(&m)
+        if msg != "" { c.JSON(http.StatusBadRequest, gin.H{"error": msg}); return }
+        var rules *string
+        if hasRules { rules = rulesJSON(&m.Rules) }
+        headers, _ := json.Marshal(m.Headers)
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        err := database.Pool.QueryRow(ctx, `INSERT INTO column_mappings(user_id, org_id, header_key, headers, name, header_row, sales_column, bill_column, date_column, rules)
+            VALUES($1,$2,$3,$4::jsonb,$5,0,$6,$7,NULLIF($8,''),$9::jsonb)
+            ON CONFLICT (org_id, header_key) DO NOTHING
+            RETURNING id, created_at, updated_at`,
+            c.GetInt64("user_id"), c.GetInt64("org_id"), key, string(headers), m.Name, m.SalesColumn, m.BillColumn, m.DateColumn, rules).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt)
+        if errors.Is(err, pgx.ErrNoRows) { c.JSON(http.StatusConflict, gin.H{"error":"a mapping for these headers already exists"}); return }
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db insert error"}); return }
+        c.JSON(http.StatusCreated, m)
+    }
+}
+
+// UpdateColumnMapping renames a mapping or changes its sales, bill and date
+// columns or its cleaning rules. The header set identifies the mapping and
+// cannot be changed.
+func UpdateColumnMapping() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+        if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid id"}); return }
+        var req mappingRequest
+        if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid body"}); return }
+        if req.Headers != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"headers cannot be changed; create a new mapping"}); return }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        orgID := c.GetInt64("org_id")
+        var m ColumnMapping
+        if err := scanMapping(database.Pool.QueryRow(ctx, `SELECT `+mappingColumns+` FROM column_mappings WHERE id=$1 AND org_id=$2`, id, orgID), &m); err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error":"mapping not found"}); return
+        }
+        if len(m.Headers) == 0 && (req.SalesColumn != nil || req.BillColumn != nil || req.DateColumn != nil || len(req.Rules) > 0) {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"legacy mapping has no headers; delete it and confirm an upload instead"}); return
+        }
+        msg, hasRules := req.apply(&m)
+        if msg != "" { c.JSON(http.StatusBadRequest, gin.H{"error": msg}); return }
+        var rules *string
+        if hasRules { rules = rulesJSON(&m.Rules) }
+        err = database.Pool.QueryRow(ctx, `UPDATE column_mappings SET name=$1, sales_column=$2, bill_column=$3, date_column=NULLIF($4,''), rules=COALESCE($5::jsonb, rules), updated_at=now()
+            WHERE id=$6 AND org_id=$7 RETURNING updated_at`, m.Name, m.SalesColumn, m.BillColumn, m.DateColumn, rules, id, orgID).Scan(&m.UpdatedAt)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db update error"}); return }
+        c.JSON(http.StatusOK, m)
+    }
+}
+
+func DeleteColumnMapping() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+        if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid id"}); return }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        res, err := database.Pool.Exec(ctx, `DELETE FROM column_mappings WHERE id=$1 AND org_id=$2`, id, c.GetInt64("org_id"))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        if res.RowsAffected() == 0 { c.JSON(http.StatusNotFound, gin.H{"error":"mapping not found"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"deleted"})
+    }
+}
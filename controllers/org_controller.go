@@ -0,0 +1,326 @@
+package controllers
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v5"
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/models"
+    "scalingwolf-ai/backend/utils"
+)
+
+const orgInviteTTL = 7 * 24 * time.Hour
+
+// createOrg creates an organization owned by uid. It becomes the user's
+// active org only if they have none yet.
+func createOrg(ctx context.Context, uid int64, name string) (int64, error) {
+    tx, err := database.Pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+    var orgID int64
+    if err := tx.QueryRow(ctx, `INSERT INTO organizations(name, created_by) VALUES($1,$2) RETURNING id`, name, uid).Scan(&orgID); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `INSERT INTO org_members(org_id, user_id, role) VALUES($1,$2,'owner')`, orgID, uid); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `UPDATE users SET active_org_id=$1 WHERE id=$2 AND active_org_id IS NULL`, orgID, uid); err != nil {
+        return 0, err
+    }
+    return orgID, tx.Commit(ctx)
+}
+
+// activeOrg returns the org a new session should start in: the user's last
+// active org if they are still a member, else their oldest membership. Users
+// without any membership get a personal org.
+func activeOrg(ctx context.Context, uid int64) (int64, error) {
+    var orgID int64
+    err := database.Pool.QueryRow(ctx, `SELECT m.org_id FROM org_members m JOIN users u ON u.id=m.user_id
+WHERE m.user_id=$1
+ORDER BY COALESCE(m.org_id = u.active_org_id, false) DESC, m.created_at, m.org_id LIMIT 1`, uid).Scan(&orgID)
+    if errors.Is(err, pgx.ErrNoRows) {
+        var name string
+        _ = database.Pool.QueryRow(ctx, `SELECT name FROM users WHERE id=$1`, uid).Scan(&name)
+        return createOrg(ctx, uid, name)
+    }
+    return orgID, err
+}
+
+// sessionOrg keeps the session on orgID while uid is still a member there,
+// otherwise moves it to activeOrg.
+func sessionOrg(ctx context.Context, sid, uid, orgID int64) (int64, error) {
+    if orgID != 0 {
+        var member bool
+        if err := database.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM org_members WHERE org_id=$1 AND user_id=$2)`, orgID, uid).Scan(&member); err != nil {
+            return 0, err
+        }
+        if member {
+            return orgID, nil
+        }
+    }
+    next, err := activeOrg(ctx, uid)
+    if err != nil {
+        return 0, err
+    }
+    _, err = database.Pool.Exec(ctx, `UPDATE sessions SET org_id=$1 WHERE id=$2`, next, sid)
+    return next, err
+}
+
+// ListOrgs returns every organization the user belongs to.
+func ListOrgs() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rows, err := database.Pool.Query(ctx, `SELECT o.id, o.name, m.role, o.created_at
+FROM org_members m JOIN organizations o ON o.id=m.org_id
+WHERE m.user_id=$1 ORDER BY o.created_at`, uid)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []models.Organization{}
+        for rows.Next() {
+            var o models.Organization
+            if err := rows.Scan(&o.ID, &o.Name, &o.Role, &o.CreatedAt); err != nil { continue }
+            o.Active = o.ID == orgID
+            out = append(out, o)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out})
+    }
+}
+
+// CreateOrg creates a new organization with the caller as owner.
+func CreateOrg() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.OrgCreateRequest
+        if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"name required"}); return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        id, err := createOrg(ctx, c.GetInt64("user_id"), strings.TrimSpace(req.Name))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"id": id, "name": strings.TrimSpace(req.Name), "role": models.RoleOwner})
+    }
+}
+
+// SwitchOrg moves the current session to another organization and returns an
+// access token for it. The refresh token stays valid.
+func SwitchOrg(cfg config.Config) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.OrgSwitchRequest
+        if err := c.ShouldBindJSON(&req); err != nil || req.OrgID <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"org_id required"}); return
+        }
+        uid, sid := c.GetInt64("user_id"), c.GetInt64("session_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        var member bool
+        _ = database.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM org_members WHERE org_id=$1 AND user_id=$2)`, req.OrgID, uid).Scan(&member)
+        if !member { c.JSON(http.StatusNotFound, gin.H{"error":"organization not found"}); return }
+        if _, err := database.Pool.Exec(ctx, `UPDATE sessions SET org_id=$1 WHERE id=$2 AND user_id=$3`, req.OrgID, sid, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        if _, err := database.Pool.Exec(ctx, `UPDATE users SET active_org_id=$1 WHERE id=$2`, req.OrgID, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        tokens, err := sessionTokens(cfg, uid, sid, req.OrgID, "")
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"token error"}); return }
+        c.JSON(http.StatusOK, tokens)
+    }
+}
+
+// ListOrgMembers lists the members of the active organization.
+func ListOrgMembers() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rows, err := database.Pool.Query(ctx, `SELECT u.id, u.name, u.email, m.role, m.created_at
+FROM org_members m JOIN users u ON u.id=m.user_id
+WHERE m.org_id=$1 ORDER BY m.created_at`, c.GetInt64("org_id"))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []models.OrgMember{}
+        for rows.Next() {
+            var m models.OrgMember
+            if err := rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil { continue }
+            out = append(out, m)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out})
+    }
+}
+
+// changeMember applies fn to the membership of target in orgID inside a
+// transaction that locks the org's owners. dropsOwner says whether the change
+// takes the owner role away, which is refused for the last owner. It returns
+// the HTTP status and error message to send.
+func changeMember(ctx context.Context, orgID, target int64, callerRole string, dropsOwner bool, fn func(tx pgx.Tx, currentRole string) error) (int, string) {
+    tx, err := database.Pool.Begin(ctx)
+    if err != nil { return http.StatusInternalServerError, "db error" }
+    defer tx.Rollback(ctx)
+    rows, err := tx.Query(ctx, `SELECT user_id FROM org_members WHERE org_id=$1 AND role='owner' FOR UPDATE`, orgID)
+    if err != nil { return http.StatusInternalServerError, "db error" }
+    owners := 0
+    targetIsOwner := false
+    for rows.Next() {
+        var id int64
+        if rows.Scan(&id) == nil {
+            owners++
+            targetIsOwner = targetIsOwner || id == target
+        }
+    }
+    rows.Close()
+    var current string
+    if err := tx.QueryRow(ctx, `SELECT role FROM org_members WHERE org_id=$1 AND user_id=$2`, orgID, target).Scan(&current); err != nil {
+        return http.StatusNotFound, "member not found"
+    }
+    if current == models.RoleOwner && callerRole != models.RoleOwner {
+        return http.StatusForbidden, "only owners can change an owner"
+    }
+    if dropsOwner && targetIsOwner && owners == 1 {
+        return http.StatusConflict, "organization must keep at least one owner"
+    }
+    if err := fn(tx, current); err != nil { return http.StatusInternalServerError, "db error" }
+    if err := tx.Commit(ctx); err != nil { return http.StatusInternalServerError, "db error" }
+    return http.StatusOK, ""
+}
+
+// UpdateOrgMember changes a member's role. Only owners may grant or revoke
+// the owner role.
+func UpdateOrgMember() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        target, _ := strconv.ParseInt(c.Param("user_id"), 10, 64)
+        var req models.OrgMemberRoleRequest
+        if err := c.ShouldBindJSON(&req); err != nil || !models.ValidRole(req.Role) {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"role must be owner, admin, analyst or viewer"}); return
+        }
+        callerRole := c.GetString("org_role")
+        if req.Role == models.RoleOwner && callerRole != models.RoleOwner {
+            c.JSON(http.StatusForbidden, gin.H{"error":"only owners can grant the owner role"}); return
+        }
+        orgID := c.GetInt64("org_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        status, msg := changeMember(ctx, orgID, target, callerRole, req.Role != models.RoleOwner, func(tx pgx.Tx, current string) error {
+            if current == req.Role { return nil }
+            _, err := tx.Exec(ctx, `UPDATE org_members SET role=$1 WHERE org_id=$2 AND user_id=$3`, req.Role, orgID, target)
+            return err
+        })
+        if status != http.StatusOK { c.JSON(status, gin.H{"error": msg}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"ok", "user_id": target, "role": req.Role})
+    }
+}
+
+// RemoveOrgMember removes a member from the active organization. Their
+// sessions in this org stop working and move elsewhere on next refresh.
+func RemoveOrgMember() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        target, _ := strconv.ParseInt(c.Param("user_id"), 10, 64)
+        orgID := c.GetInt64("org_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        status, msg := changeMember(ctx, orgID, target, c.GetString("org_role"), true, func(tx pgx.Tx, current string) error {
+            _, err := tx.Exec(ctx, `DELETE FROM org_members WHERE org_id=$1 AND user_id=$2`, orgID, target)
+            return err
+        })
+        if status != http.StatusOK { c.JSON(status, gin.H{"error": msg}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"removed"})
+    }
+}
+
+// CreateOrgInvite creates an invite for an email address and returns the
+// single-use token to share with the invitee.
+func CreateOrgInvite() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.OrgInviteRequest
+        if err := c.ShouldBindJSON(&req); err != nil || !strings.Contains(req.Email, "@") {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"valid email required"}); return
+        }
+        if req.Role == "" { req.Role = models.RoleViewer }
+        if !models.ValidRole(req.Role) {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"role must be owner, admin, analyst or viewer"}); return
+        }
+        if req.Role == models.RoleOwner && c.GetString("org_role") != models.RoleOwner {
+            c.JSON(http.StatusForbidden, gin.H{"error":"only owners can invite owners"}); return
+        }
+        token, tokenHash, err := utils.NewOpaqueToken()
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"token error"}); return }
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        expires := time.Now().Add(orgInviteTTL)
+        var id int64
+        err = database.Pool.QueryRow(ctx, `INSERT INTO org_invites(org_id, email, role, token_hash, invited_by, expires_at)
+VALUES($1,$2,$3,$4,$5,$6) RETURNING id`, c.GetInt64("org_id"), strings.TrimSpace(req.Email), req.Role, tokenHash, c.GetInt64("user_id"), expires).Scan(&id)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"id": id, "token": token, "email": strings.TrimSpace(req.Email), "role": req.Role, "expires_at": expires})
+    }
+}
+
+// ListOrgInvites lists pending invites of the active organization.
+func ListOrgInvites() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rows, err := database.Pool.Query(ctx, `SELECT id, email, role, created_at, expires_at FROM org_invites
+WHERE org_id=$1 AND accepted_at IS NULL AND expires_at > now() ORDER BY created_at DESC`, c.GetInt64("org_id"))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []models.OrgInvite{}
+        for rows.Next() {
+            var i models.OrgInvite
+            if err := rows.Scan(&i.ID, &i.Email, &i.Role, &i.CreatedAt, &i.ExpiresAt); err != nil { continue }
+            out = append(out, i)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out})
+    }
+}
+
+// RevokeOrgInvite deletes a pending invite.
+func RevokeOrgInvite() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        res, err := database.Pool.Exec(ctx, `DELETE FROM org_invites WHERE id=$1 AND org_id=$2 AND accepted_at IS NULL`, id, c.GetInt64("org_id"))
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        if res.RowsAffected() == 0 { c.JSON(http.StatusNotFound, gin.H{"error":"invite not found"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"revoked"})
+    }
+}
+
+// AcceptOrgInvite adds the caller to the inviting organization. The invite
+// must be addressed to the caller's email. Existing members keep their role.
+func AcceptOrgInvite() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req models.OrgInviteAcceptRequest
+        if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"token required"}); return
+        }
+        uid := c.GetInt64("user_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        tx, err := database.Pool.Begin(ctx)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer tx.Rollback(ctx)
+        var orgID int64
+        var role string
+        err = tx.QueryRow(ctx, `UPDATE org_invites SET accepted_at=now(), accepted_by=$2
+WHERE token_hash=$1 AND accepted_at IS NULL AND expires_at > now()
+  AND lower(email) = (SELECT lower(email) FROM users WHERE id=$2)
+RETURNING org_id, role`, utils.HashToken(req.Token), uid).Scan(&orgID, &role)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"invite not found or expired"}); return }
+        if _, err := tx.Exec(ctx, `INSERT INTO org_members(org_id, user_id, role) VALUES($1,$2,$3) ON CONFLICT (org_id, user_id) DO NOTHING`, orgID, uid, role); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return
+        }
+        if err := tx.Commit(ctx); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        c.JSON(http.StatusOK, gin.H{"status":"joined", "org_id": orgID, "role": role})
+    }
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/utils"
+)
+
+// tokensPerPoint converts plan points to tokens.
+const tokensPerPoint = 10000
+
+const defaultPlanCode = "free"
+
+// quotaState is a user's token budget for the current calendar month.
+type quotaState struct {
+    Quota       int64
+    Used        int64
+    PeriodStart time.Time
+    PeriodEnd   time.Time
+    PlanCode    string
+    PlanName    string
+    PlanPoints  int
+    Features    json.RawMessage
+}
+
+// rollQuotaPeriod creates the user's quota row on the default plan if needed
+// and, once the stored period has ended, archives it to token_usage_periods
+// and starts the current month with zero usage.
+func rollQuotaPeriod(ctx context.Context, uid int64) error {
+    _, err := database.Pool.Exec(ctx, `
+        INSERT INTO token_quotas(user_id, plan_id, token_quota, token_used, period_start, period_end, updated_at)
+        SELECT $1, p.id, p.monthly_points::bigint * $3, 0, date_trunc('month', now()), date_trunc('month', now()) + interval '1 month', now()
+        FROM plans p WHERE p.code=$2
+        ON CONFLICT (user_id) DO NOTHING`, uid, defaultPlanCode, tokensPerPoint)
+    if err != nil {
+        return err
+    }
+    _, err = database.Pool.Exec(ctx, `
+        WITH ended AS (
+            SELECT user_id, period_start, period_end, plan_id, token_quota, token_used
+            FROM token_quotas WHERE user_id=$1 AND period_end <= now()
+            FOR UPDATE
+        ), archived AS (
+            INSERT INTO token_usage_periods(user_id, period_start, period_end, plan_id, token_quota, token_used)
+            SELECT user_id, period_start, period_end, plan_id, token_quota, token_used FROM ended
+            ON CONFLICT (user_id, period_start) DO NOTHING
+        )
+        UPDATE token_quotas q
+        SET token_used=0, period_start=date_trunc('month', now()), period_end=date_trunc('month', now()) + interval '1 month', updated_at=now()
+        FROM ended WHERE q.user_id=ended.user_id`, uid)
+    return err
+}
+
+// loadQuota returns the user's current-period quota, rolling the period first.
+func loadQuota(ctx context.Context, uid int64) (quotaState, error) {
+    var q quotaState
+    if err := rollQuotaPeriod(ctx, uid); err != nil {
+        return q, err
+    }
+    var features string
+    err := database.Pool.QueryRow(ctx, `
+        SELECT t.token_quota::bigint, t.token_used::bigint, t.period_start, t.period_end, p.code, p.name, p.monthly_points, p.features::text
+        FROM token_quotas t JOIN plans p ON p.id=t.plan_id
+        WHERE t.user_id=$1`, uid).
+        Scan(&q.Quota, &q.Used, &q.PeriodStart, &q.PeriodEnd, &q.PlanCode, &q.PlanName, &q.PlanPoints, &features)
+    q.Features = json.RawMessage(features)
+    return q, err
+}
+
+// modelName labels ledger rows with the generation model in use.
+func modelName(cfg config.Config) string {
+    if cfg.AIProvider == utils.ProviderFake {
+        return utils.ProviderFake
+    }
+    return cfg.GeminiModel
+}
+
+// recordUsage appends a ledger row for one model call and charges it to the
+// user's current period.
+func recordUsage(ctx context.Context, cfg config.Config, uid, orgID int64, call utils.MeteredCall) error {
+    u := call.Usage
+    total := u.TotalTokens
+    if total <= 0 {
+        total = u.PromptTokens + u.OutputTokens
+    }
+    if total <= 0 {
+        return nil
+    }
+    if err := rollQuotaPeriod(ctx, uid); err != nil {
+        return err
+    }
+    var org any
+    if orgID != 0 {
+        org = orgID
+    }
+    kind := call.Kind
+    if kind == "" {
+        kind = utils.CallGenerate
+    }
+    _, err := database.Pool.Exec(ctx, `
+        WITH entry AS (
+            INSERT INTO token_usage_ledger(user_id, org_id, feature, kind, model, prompt_tokens, output_tokens, total_tokens, estimated)
+            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+        )
+        UPDATE token_quotas SET token_used = token_used + $8, updated_at=now() WHERE user_id=$1`,
+        uid, org, call.Feature, kind, modelName(cfg), u.PromptTokens, u.OutputTokens, total, call.Estimated)
+    return err
+}
+
+const (
+    // maxInFlight caps concurrent model calls per user; more are refused with 429.
+    maxInFlight = 4
+    // reservationTTL bounds how long a hold left by a crashed call counts.
+    reservationTTL = 5 * time.Minute
+)
+
+// quotaError explains why a reservation was refused. It unwraps to
+// utils.ErrQuotaExceeded (402) or utils.ErrQuotaBusy (429).
+type quotaError struct {
+    busy      bool
+    quota     int64
+    used      int64
+    reserved  int64
+    requested int64
+    resetsAt  time.Time
+}
+
+func (e *quotaError) Error() string { return e.Unwrap().Error() }
+
+func (e *quotaError) Unwrap() error {
+    if e.busy {
+        return utils.ErrQuotaBusy
+    }
+    return utils.ErrQuotaExceeded
+}
+
+// quotaMeter is the utils.Meter for one user acting in one organization.
+type quotaMeter struct {
+    cfg    config.Config
+    userID int64
+    orgID  int64
+}
+
+func meterFor(cfg config.Config, uid, orgID int64) utils.Meter {
+    return quotaMeter{cfg: cfg, userID: uid, orgID: orgID}
+}
+
+// Reserve holds tokens against the current period under a lock on the
+// user's quota row, so concurrent calls see each other's holds. A request
+// larger than what is left is refused: nothing caps the model's output to
+// a smaller hold, so a trimmed one would let the call overspend.
+func (m quotaMeter) Reserve(ctx context.Context, feature string, tokens int64) (utils.Reservation, error) {
+    if err := rollQuotaPeriod(ctx, m.userID); err != nil {
+        return nil, err
+    }
+    tx, err := database.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+    e := &quotaError{requested: tokens}
+    if err := tx.QueryRow(ctx, `SELECT token_quota::bigint, token_used::bigint, period_end FROM token_quotas WHERE user_id=$1 FOR UPDATE`, m.userID).
+        Scan(&e.quota, &e.used, &e.resetsAt); err != nil {
+        return nil, err
+    }
+    var inFlight int
+    if err := tx.QueryRow(ctx, `SELECT COUNT(*), COALESCE(SUM(tokens),0)::bigint FROM token_reservations WHERE user_id=$1 AND expires_at > now()`, m.userID).
+        Scan(&inFlight, &e.reserved); err != nil {
+        return nil, err
+    }
+    if inFlight >= maxInFlight {
+        e.busy = true
+        return nil, e
+    }
+    if left := e.quota - e.used - e.reserved; left <= 0 || tokens > left {
+        return nil, e
+    }
+    var org any
+    if m.orgID != 0 {
+        org = m.orgID
+    }
+    r := &quotaReservation{meter: m}
+    if err := tx.QueryRow(ctx, `INSERT INTO token_reservations(user_id, org_id, feature, tokens, expires_at) VALUES($1,$2,$3,$4,$5) RETURNING id`,
+        m.userID, org, feature, tokens, time.Now().Add(reservationTTL)).Scan(&r.id); err != nil {
+        return nil, err
+    }
+    if _, err := tx.Exec(ctx, `DELETE FROM token_reservations WHERE user_id=$1 AND expires_at <= now()`, m.userID); err != nil {
+        return nil, err
+    }
+    return r, tx.Commit(ctx)
+}
+
+// quotaReservation settles or releases on its own deadline, so the hold is
+// cleared even when the request context is already done.
+type quotaReservation struct {
+    meter quotaMeter
+    id    int64
+}
+
+func (r *quotaReservation) Settle(call utils.MeteredCall) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    m := r.meter
+    // Charge before dropping the hold so the tokens are never uncounted.
+    if err := recordUsage(ctx, m.cfg, m.userID, m.orgID, call); err != nil {
+        log.Printf("usage record error (user %d, %s): %v", m.userID, call.Feature, err)
+    }
+    r.release(ctx)
+}
+
+func (r *quotaReservation) Release() {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    r.release(ctx)
+}
+
+func (r *quotaReservation) release(ctx context.Context) {
+    if _, err := database.Pool.Exec(ctx, `DELETE FROM token_reservations WHERE id=$1`, r.id); err != nil {
+        log.Printf("reservation release error (%d): %v", r.id, err)
+    }
+}
+
+// reservedTokens sums the user's live reservations.
+func reservedTokens(ctx context.Context, uid int64) int64 {
+    var n int64
+    _ = database.Pool.QueryRow(ctx, `SELECT COALESCE(SUM(tokens),0)::bigint FROM token_reservations WHERE user_id=$1 AND expires_at > now()`, uid).Scan(&n)
+    return n
+}
+
+// writeQuotaError responds 402 or 429 when err is a refused reservation and
+// reports whether it did.
+func writeQuotaError(c *gin.Context, err error) bool {
+    var e *quotaError
+    if !errors.As(err, &e) {
+        return false
+    }
+    status, code := http.StatusPaymentRequired, "quota_exceeded"
+    if e.busy {
+        status, code = http.StatusTooManyRequests, "too_many_requests"
+        c.Header("Retry-After", "5")
+    }
+    remaining := e.quota - e.used - e.reserved
+    if remaining < 0 {
+        remaining = 0
+    }
+    c.JSON(status, gin.H{
+        "error":            e.Error(),
+        "code":             code,
+        "token_quota":      e.quota,
+        "token_used":       e.used,
+        "token_reserved":   e.reserved,
+        "tokens_requested": e.requested,
+        "remaining":        remaining,
+        "resets_at":        e.resetsAt,
+    })
+    return true
+}
@@ -26,18 +26,21 @@ func RAGUpsertText(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body or missing text"})
             return
         }
-        uid := c.GetInt64("user_id")
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
         defer cancel()
 
-        aiClient, err := utils.NewAIClient(ctx, utils.AIConfig{APIKey: cfg.GeminiAPIKey, GenModel: cfg.GeminiModel, EmbedModel: cfg.GeminiEmbeddingModel})
+        aiClient, err := newAI(ctx, cfg, meterFor(cfg, uid, orgID), "rag_index")
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "ai client error"})
             return
         }
         defer aiClient.Close()
 
-        emb, err := utils.EmbedText(ctx, aiClient, cfg.GeminiEmbeddingModel, req.Text)
+        emb, err := utils.EmbedText(ctx, aiClient, req.Text)
+        if writeQuotaError(c, err) {
+            return
+        }
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "embedding failed"})
             return
@@ -49,8 +52,8 @@ func RAGUpsertText(cfg config.Config) gin.HandlerFunc {
         // Insert with vector literal cast
         vec := utils.VectorLiteral(emb)
         _, err = database.Pool.Exec(ctx,
-            `INSERT INTO rag_documents(user_id, content, metadata, embedding) VALUES ($1, $2, $3::jsonb, $4::vector)`,
-            uid, req.Text, string(mb), vec,
+            `INSERT INTO rag_documents(user_id, org_id, content, metadata, embedding) VALUES ($1, $2, $3, $4::jsonb, $5::vector)`,
+            uid, orgID, req.Text, string(mb), vec,
         )
         if err != nil {
             log.Printf("rag upsert insert error: %v", err)
@@ -61,6 +64,10 @@ func RAGUpsertText(cfg config.Config) gin.HandlerFunc {
     }
 }
 
+// ragVisible limits rag_documents to the org ($1) while keeping chat
+// summaries private to the member ($2) whose chat they summarize.
+const ragVisible = `org_id=$1 AND (metadata->>'type' IS DISTINCT FROM 'chat_summary' OR user_id=$2)`
+
 type RAGSearchRequest struct {
     Query string `json:"query"`
     K     int    `json:"k"`
@@ -73,16 +80,17 @@ func RAGSearch(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error":"invalid body or missing query"}); return
         }
         if req.K <= 0 || req.K > 10 { req.K = 5 }
-        uid := c.GetInt64("user_id")
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
         defer cancel()
-        aiClient, err := utils.NewAIClient(ctx, utils.AIConfig{APIKey: cfg.GeminiAPIKey, GenModel: cfg.GeminiModel, EmbedModel: cfg.GeminiEmbeddingModel})
+        aiClient, err := newAI(ctx, cfg, meterFor(cfg, uid, orgID), "rag_search")
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"ai client error"}); return }
         defer aiClient.Close()
-        emb, err := utils.EmbedText(ctx, aiClient, cfg.GeminiEmbeddingModel, req.Query)
+        emb, err := utils.EmbedText(ctx, aiClient, req.Query)
+        if writeQuotaError(c, err) { return }
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"embedding failed"}); return }
         vec := utils.VectorLiteral(emb)
-        rows, err := database.Pool.Query(ctx, `SELECT content FROM rag_documents WHERE user_id=$1 ORDER BY embedding <-> $2::vector LIMIT $3`, uid, vec, req.K)
+        rows, err := database.Pool.Query(ctx, `SELECT content FROM rag_documents WHERE `+ragVisible+` ORDER BY embedding <-> $3::vector LIMIT $4`, orgID, uid, vec, req.K)
         if err != nil { log.Printf("rag search query error: %v", err); c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
         defer rows.Close()
         docs := []string{}
@@ -103,23 +111,24 @@ func RAGUpsertChunks(cfg config.Config) gin.HandlerFunc {
         if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Text)=="" {
             c.JSON(http.StatusBadRequest, gin.H{"error":"invalid body or missing text"}); return
         }
-        uid := c.GetInt64("user_id")
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
         size := req.ChunkSize
         if size < 400 || size > 1600 { size = 800 }
         chunks := chunkText(req.Text, size)
         ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
         defer cancel()
-        aiClient, err := utils.NewAIClient(ctx, utils.AIConfig{APIKey: cfg.GeminiAPIKey, GenModel: cfg.GeminiModel, EmbedModel: cfg.GeminiEmbeddingModel})
+        aiClient, err := newAI(ctx, cfg, meterFor(cfg, uid, orgID), "rag_index")
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"ai client error"}); return }
         defer aiClient.Close()
         meta := req.Metadata
         if meta == nil { meta = map[string]any{} }
         mb, _ := json.Marshal(meta)
         for _, ch := range chunks {
-            emb, err := utils.EmbedText(ctx, aiClient, cfg.GeminiEmbeddingModel, ch)
+            emb, err := utils.EmbedText(ctx, aiClient, ch)
+            if writeQuotaError(c, err) { return }
             if err != nil { continue }
             vec := utils.VectorLiteral(emb)
-            _, _ = database.Pool.Exec(ctx, `INSERT INTO rag_documents(user_id, content, metadata, embedding) VALUES($1,$2,$3::jsonb, $4::vector)`, uid, ch, string(mb), vec)
+            _, _ = database.Pool.Exec(ctx, `INSERT INTO rag_documents(user_id, org_id, content, metadata, embedding) VALUES($1,$2,$3,$4::jsonb, $5::vector)`, uid, orgID, ch, string(mb), vec)
         }
         c.JSON(http.StatusOK, gin.H{"status":"ok", "chunks": len(chunks)})
     }
@@ -2,9 +2,12 @@ package controllers
 
 import (
     "context"
+    "encoding/csv"
     "encoding/json"
+    "math"
     "net/http"
     "regexp"
+    "sort"
     "strconv"
     "strings"
     "time"
@@ -12,6 +15,8 @@ import (
     "github.com/gin-gonic/gin"
     "scalingwolf-ai/backend/config"
     "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/ingestion"
+    "scalingwolf-ai/backend/utils"
 )
 
 type SalesTextRequest struct {
@@ -28,7 +33,7 @@ func IngestSalesText(cfg config.Config) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
             return
         }
-        uid := c.GetInt64("user_id")
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
 
         total, rows, uniq := detectSalesFromRequest(req)
         payload := map[string]any{
@@ -38,7 +43,7 @@ func IngestSalesText(cfg config.Config) gin.HandlerFunc {
         pb, _ := json.Marshal(payload)
         ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
         defer cancel()
-        _, err := database.Pool.Exec(ctx, `INSERT INTO sales_metrics(user_id, source_type, payload, total_sales, bill_row_count, unique_bill_count) VALUES($1,'text',$2::jsonb,$3,$4,$5)`, uid, string(pb), total, rows, uniq)
+        _, err := database.Pool.Exec(ctx, `INSERT INTO sales_metrics(user_id, org_id, source_type, payload, total_sales, bill_row_count, unique_bill_count) VALUES($1,$2,'text',$3::jsonb,$4,$5,$6)`, uid, orgID, string(pb), total, rows, uniq)
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert error"}); return }
         c.JSON(http.StatusOK, gin.H{"status":"ok", "metrics": gin.H{"total_sales": total, "bill_row_count": rows, "unique_bill_count": uniq}})
     }
@@ -54,10 +59,10 @@ type SalesMetric struct {
     CreatedAt       time.Time       `json:"created_at"`
 }
 
-// ListSalesMetrics returns paginated sales metrics for the authenticated user
+// ListSalesMetrics returns paginated sales metrics for the active organization
 func ListSalesMetrics() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
+        orgID := c.GetInt64("org_id")
         limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
         offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
         if limit <= 0 || limit > 100 { limit = 20 }
@@ -66,9 +71,9 @@ func ListSalesMetrics() gin.HandlerFunc {
         defer cancel()
         rows, err := database.Pool.Query(ctx, `
             SELECT id, source_type, payload::text, total_sales::float8, bill_row_count::int, unique_bill_count::int, created_at
-            FROM sales_metrics WHERE user_id=$1
+            FROM sales_metrics WHERE org_id=$1
             ORDER BY created_at DESC
-            LIMIT $2 OFFSET $3`, uid, limit, offset)
+            LIMIT $2 OFFSET $3`, orgID, limit, offset)
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
         defer rows.Close()
         out := []SalesMetric{}
@@ -83,10 +88,10 @@ func ListSalesMetrics() gin.HandlerFunc {
     }
 }
 
-// GetSalesMetric returns a single sales metric by id for the authenticated user
+// GetSalesMetric returns a single sales metric by id for the active organization
 func GetSalesMetric() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
+        orgID := c.GetInt64("org_id")
         idStr := c.Param("id")
         id, _ := strconv.ParseInt(idStr, 10, 64)
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -95,7 +100,7 @@ func GetSalesMetric() gin.HandlerFunc {
         var payloadText string
         err := database.Pool.QueryRow(ctx, `
             SELECT id, source_type, payload::text, total_sales::float8, bill_row_count::int, unique_bill_count::int, created_at
-            FROM sales_metrics WHERE id=$1 AND user_id=$2`, id, uid,
+            FROM sales_metrics WHERE id=$1 AND org_id=$2`, id, orgID,
         ).Scan(&m.ID, &m.SourceType, &payloadText, &m.TotalSales, &m.BillRowCount, &m.UniqueBillCount, &m.CreatedAt)
         if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
         m.Payload = json.RawMessage(payloadText)
@@ -103,17 +108,17 @@ func GetSalesMetric() gin.HandlerFunc {
     }
 }
 
-// GetLatestSalesMetric returns the most recent sales metric for the authenticated user
+// GetLatestSalesMetric returns the most recent sales metric for the active organization
 func GetLatestSalesMetric() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
+        orgID := c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
         var m SalesMetric
         var payloadText string
         err := database.Pool.QueryRow(ctx, `
             SELECT id, source_type, payload::text, total_sales::float8, bill_row_count::int, unique_bill_count::int, created_at
-            FROM sales_metrics WHERE user_id=$1 ORDER BY created_at DESC LIMIT 1`, uid,
+            FROM sales_metrics WHERE org_id=$1 ORDER BY created_at DESC LIMIT 1`, orgID,
         ).Scan(&m.ID, &m.SourceType, &payloadText, &m.TotalSales, &m.BillRowCount, &m.UniqueBillCount, &m.CreatedAt)
         if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"no sales metrics"}); return }
         m.Payload = json.RawMessage(payloadText)
@@ -155,3 +160,352 @@ func extractAllInts(s string) []int {
     }
     return out
 }
+
+// TimeBucket is the sales total and bill counts for one day, week or month.
+// TotalSales and the bill counts cover every row, like the sales metrics;
+// GrossSales, Returns and NetSales split them as ingestion.Revenue does.
+type TimeBucket struct {
+    Period      string  `json:"period"` // first day of the period, YYYY-MM-DD
+    TotalSales  float64 `json:"total_sales"`
+    GrossSales  float64 `json:"gross_sales"`
+    Returns     float64 `json:"returns"`
+    NetSales    float64 `json:"net_sales"`
+    BillRows    int     `json:"bill_row_count"`
+    UniqueBills int     `json:"unique_bill_count"`
+}
+
+// salesTimeSeries aggregates dated transactions into daily, weekly (Monday
+// start) and monthly buckets. It returns nil when no row carries a date.
+func salesTimeSeries(txns []ingestion.Transaction) gin.H {
+    type acc struct {
+        total   float64
+        gross   float64
+        returns float64
+        rows    int
+        bills   map[string]struct{}
+    }
+    series := map[string]map[string]*acc{"daily": {}, "weekly": {}, "monthly": {}}
+    dated := 0
+    for _, t := range txns {
+        if t.TxnDate == nil { continue }
+        d, err := time.Parse("2006-01-02", *t.TxnDate)
+        if err != nil { continue }
+        dated++
+        keys := map[string]string{
+            "daily":   d.Format("2006-01-02"),
+            "weekly":  utils.WeekStart(d).Format("2006-01-02"),
+            "monthly": time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02"),
+        }
+        for g, k := range keys {
+            a := series[g][k]
+            if a == nil {
+                a = &acc{bills: map[string]struct{}{}}
+                series[g][k] = a
+            }
+            amt := 0.0
+            if t.Amount != nil { amt = *t.Amount }
+            a.total += amt
+            a.rows++
+            if t.BillID != "" { a.bills[ingestion.BillKey(t.BillID)] = struct{}{} }
+            switch t.Kind {
+            case ingestion.KindSale:
+                a.gross += amt
+            case ingestion.KindReturn:
+                a.returns += math.Abs(amt)
+            }
+        }
+    }
+    if dated == 0 { return nil }
+    out := gin.H{"dated_rows": dated, "undated_rows": len(txns) - dated}
+    for g, m := range series {
+        buckets := make([]TimeBucket, 0, len(m))
+        for k, a := range m {
+            buckets = append(buckets, TimeBucket{Period: k, TotalSales: round2(a.total), GrossSales: round2(a.gross), Returns: round2(a.returns), NetSales: round2(a.gross - a.returns), BillRows: a.rows, UniqueBills: len(a.bills)})
+        }
+        sort.Slice(buckets, func(i, j int) bool { return buckets[i].Period < buckets[j].Period })
+        out[g] = buckets
+    }
+    return out
+}
+
+// txnInsertBatch bounds the JSON document sent per INSERT for large uploads.
+const txnInsertBatch = 2000
+
+// saveSalesResult is the one way a file's pipeline result is stored, for
+// uploads and chat attachments alike: the sales_metrics row with its
+// transactions, then a short summary document for RAG.
+func saveSalesResult(ctx context.Context, cfg config.Config, userID, orgID int64, fileName string, sheet sheetData, res *ingestion.Result) (int64, error) {
+    payload := map[string]any{
+        "file_name":     fileName,
+        "headers":       res.Columns.Headers,
+        "header_row":    res.Columns.HeaderRow,
+        "sales_column":  res.Columns.Sales,
+        "bill_column":   res.Columns.Bill,
+        "detected_by":   res.Detection.Source,
+        "number_format": res.NumberFormat,
+        "cleaning":      res.Report,
+        "rules":         res.Rules,
+        "revenue":       res.Revenue,
+        "bills":         res.Bills,
+    }
+    if res.Columns.Date != "" {
+        payload["date_column"] = res.Columns.Date
+    }
+    if res.Columns.Status != "" {
+        payload["status_column"] = res.Columns.Status
+    }
+    describeSheet(payload, sheet)
+    id, err := saveSalesUpload(ctx, userID, orgID, payload, res.Metrics, res.Transactions, res.Dropped)
+    if err != nil {
+        return 0, err
+    }
+    if cfg.AIEnabled() {
+        m := res.Metrics
+        doc := "Sales metrics summary: Total sales = " + strconv.FormatFloat(round2(m.TotalSales), 'f', 2, 64) + ", bill rows = " + strconv.Itoa(m.BillRowCount) + ", unique bill IDs = " + strconv.Itoa(m.UniqueBillCount)
+        ai, err := newAI(ctx, cfg, meterFor(cfg, userID, orgID), "rag_index")
+        if err == nil {
+            emb, err := utils.EmbedText(ctx, ai, doc)
+            ai.Close()
+            if err == nil {
+                vec := utils.VectorLiteral(emb)
+                _, _ = database.Pool.Exec(ctx, `INSERT INTO rag_documents(user_id, org_id, content, metadata, embedding) VALUES($1,$2,$3,$4::jsonb, $5::vector)`, userID, orgID, doc, `{"source":"sales_metrics"}`, vec)
+            }
+        }
+    }
+    return id, nil
+}
+
+// saveSalesUpload stores the metrics row for a file upload together with its
+// transactions and dropped rows in one transaction and returns the
+// sales_metrics id.
+func saveSalesUpload(ctx context.Context, userID, orgID int64, payload map[string]any, m ingestion.Metrics, txns []ingestion.Transaction, dropped []ingestion.Dropped) (int64, error) {
+    pb, _ := json.Marshal(payload)
+    tx, err := database.Pool.Begin(ctx)
+    if err != nil { return 0, err }
+    defer tx.Rollback(ctx)
+    var id int64
+    err = tx.QueryRow(ctx, `INSERT INTO sales_metrics(user_id, org_id, source_type, payload, total_sales, bill_row_count, unique_bill_count) VALUES($1,$2,'file',$3::jsonb,$4,$5,$6) RETURNING id`,
+        userID, orgID, string(pb), round2(m.TotalSales), m.BillRowCount, m.UniqueBillCount).Scan(&id)
+    if err != nil { return 0, err }
+    for start := 0; start < len(txns); start += txnInsertBatch {
+        end := start + txnInsertBatch
+        if end > len(txns) { end = len(txns) }
+        batch, _ := json.Marshal(txns[start:end])
+        _, err := tx.Exec(ctx, `
+            INSERT INTO sales_transactions(sales_metric_id, user_id, org_id, line_no, bill_id, kind, amount, txn_date, raw)
+            SELECT $1, $2, $3, t.line_no, t.bill_id, t.kind, t.amount, t.txn_date::date, t.raw
+            FROM jsonb_to_recordset($4::jsonb) AS t(line_no int, bill_id text, kind text, amount numeric, txn_date text, raw jsonb)`,
+            id, userID, orgID, string(batch))
+        if err != nil { return 0, err }
+    }
+    for start := 0; start < len(dropped); start += txnInsertBatch {
+        end := start + txnInsertBatch
+        if end > len(dropped) { end = len(dropped) }
+        batch, _ := json.Marshal(dropped[start:end])
+        _, err := tx.Exec(ctx, `
+            INSERT INTO sales_dropped_rows(sales_metric_id, org_id, line_no, reason, raw)
+            SELECT $1, $2, d.line, d.reason, d."values"
+            FROM jsonb_to_recordset($3::jsonb) AS d(line int, reason text, "values" jsonb)`,
+            id, orgID, string(batch))
+        if err != nil { return 0, err }
+    }
+    if err := tx.Commit(ctx); err != nil { return 0, err }
+    return id, nil
+}
+
+// ListSalesRows returns the stored transactions of one upload, paginated.
+func ListSalesRows() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        orgID := c.GetInt64("org_id")
+        id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+        limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+        offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+        if limit <= 0 || limit > 500 { limit = 100 }
+        if offset < 0 { offset = 0 }
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        var total int
+        err := database.Pool.QueryRow(ctx, `
+            SELECT (SELECT COUNT(*) FROM sales_transactions t WHERE t.sales_metric_id = m.id)
+            FROM sales_metrics m WHERE m.id=$1 AND m.org_id=$2`, id, orgID).Scan(&total)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+        rows, err := database.Pool.Query(ctx, `
+            SELECT line_no, bill_id, kind, amount::float8, to_char(txn_date, 'YYYY-MM-DD'), raw::text
+            FROM sales_transactions WHERE sales_metric_id=$1
+            ORDER BY line_no
+            LIMIT $2 OFFSET $3`, id, limit, offset)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []ingestion.Transaction{}
+        for rows.Next() {
+            var t ingestion.Transaction
+            var rawText string
+            if err := rows.Scan(&t.LineNo, &t.BillID, &t.Kind, &t.Amount, &t.TxnDate, &rawText); err != nil { continue }
+            _ = json.Unmarshal([]byte(rawText), &t.Raw)
+            out = append(out, t)
+        }
+        c.JSON(http.StatusOK, gin.H{"items": out, "total": total, "limit": limit, "offset": offset})
+    }
+}
+
+// GetCleaningReport explains what cleaning left out of one upload: the counts
+// stored with it, the dropped rows per reason and the rows themselves,
+// paginated. Query: reason filters the rows, limit/offset page them.
+func GetCleaningReport() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        orgID := c.GetInt64("org_id")
+        id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+        reason := strings.TrimSpace(c.Query("reason"))
+        limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+        offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+        if limit <= 0 || limit > 500 { limit = 100 }
+        if offset < 0 { offset = 0 }
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        var fileName, reportText string
+        err := database.Pool.QueryRow(ctx, `
+            SELECT COALESCE(payload->>'file_name',''), COALESCE(payload->'cleaning','null')::text
+            FROM sales_metrics WHERE id=$1 AND org_id=$2 AND source_type='file'`, id, orgID).Scan(&fileName, &reportText)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+
+        byReason := map[string]int{}
+        rows, err := database.Pool.Query(ctx, `SELECT reason, COUNT(*)::int FROM sales_dropped_rows WHERE sales_metric_id=$1 GROUP BY reason`, id)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        total := 0
+        for rows.Next() {
+            var r string
+            var n int
+            if err := rows.Scan(&r, &n); err != nil { continue }
+            byReason[r] = n
+            if reason == "" || reason == r { total += n }
+        }
+        rows.Close()
+
+        rows, err = database.Pool.Query(ctx, `
+            SELECT line_no, reason, raw::text FROM sales_dropped_rows
+            WHERE sales_metric_id=$1 AND ($2 = '' OR reason = $2)
+            ORDER BY line_no
+            LIMIT $3 OFFSET $4`, id, reason, limit, offset)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        items := []ingestion.Dropped{}
+        for rows.Next() {
+            var d ingestion.Dropped
+            var rawText string
+            if err := rows.Scan(&d.Line, &d.Reason, &rawText); err != nil { continue }
+            _ = json.Unmarshal([]byte(rawText), &d.Values)
+            items = append(items, d)
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "sales_metrics_id": id,
+            "file_name":        fileName,
+            "report":           json.RawMessage(reportText),
+            "by_reason":        byReason,
+            "items":            items,
+            "total":            total,
+            "limit":            limit,
+            "offset":           offset,
+        })
+    }
+}
+
+// DownloadExcludedRows streams the rows cleaning left out of one upload as
+// CSV: the sheet line, the reason, then the file's own columns. Query: reason
+// filters the rows.
+func DownloadExcludedRows() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        orgID := c.GetInt64("org_id")
+        id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+        reason := strings.TrimSpace(c.Query("reason"))
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        var headersText string
+        err := database.Pool.QueryRow(ctx, `
+            SELECT COALESCE(payload->'headers','[]')::text
+            FROM sales_metrics WHERE id=$1 AND org_id=$2 AND source_type='file'`, id, orgID).Scan(&headersText)
+        if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+        var headers []string
+        _ = json.Unmarshal([]byte(headersText), &headers)
+
+        rows, err := database.Pool.Query(ctx, `
+            SELECT line_no, reason, raw::text FROM sales_dropped_rows
+            WHERE sales_metric_id=$1 AND ($2 = '' OR reason = $2)
+            ORDER BY line_no`, id, reason)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+
+        c.Header("Content-Type", "text/csv; charset=utf-8")
+        c.Header("Content-Disposition", `attachment; filename="excluded-rows-`+strconv.FormatInt(id, 10)+`.csv"`)
+        w := csv.NewWriter(c.Writer)
+        _ = w.Write(append([]string{"line", "reason"}, headers...))
+        for rows.Next() {
+            var line int
+            var r, rawText string
+            if err := rows.Scan(&line, &r, &rawText); err != nil { continue }
+            var values map[string]string
+            _ = json.Unmarshal([]byte(rawText), &values)
+            rec := []string{strconv.Itoa(line), r}
+            for _, h := range headers {
+                rec = append(rec, values[h])
+            }
+            _ = w.Write(rec)
+        }
+        w.Flush()
+    }
+}
+
+// SalesTimeSeries aggregates stored transactions by day, week or month.
+// Query: granularity=day|week|month (default day), sales_metrics_id (defaults
+// to the latest upload with dated rows), optional from/to as YYYY-MM-DD.
+func SalesTimeSeries() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        orgID := c.GetInt64("org_id")
+        trunc := map[string]string{"day": "day", "daily": "day", "week": "week", "weekly": "week", "month": "month", "monthly": "month"}[c.DefaultQuery("granularity", "day")]
+        if trunc == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"granularity must be day, week or month"}); return }
+        var from, to *string
+        for _, p := range []struct{ key string; dst **string }{{"from", &from}, {"to", &to}} {
+            if v := c.Query(p.key); v != "" {
+                if _, err := time.Parse("2006-01-02", v); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": p.key+" must be YYYY-MM-DD"}); return }
+                *p.dst = &v
+            }
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        var metricsID int64
+        if v := c.Query("sales_metrics_id"); v != "" {
+            id, _ := strconv.ParseInt(v, 10, 64)
+            var exists bool
+            _ = database.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM sales_metrics WHERE id=$1 AND org_id=$2)`, id, orgID).Scan(&exists)
+            if !exists { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+            metricsID = id
+        } else {
+            err := database.Pool.QueryRow(ctx, `
+                SELECT sales_metric_id FROM sales_transactions
+                WHERE org_id=$1 AND txn_date IS NOT NULL
+                ORDER BY sales_metric_id DESC LIMIT 1`, orgID).Scan(&metricsID)
+            if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"no dated sales rows; upload a file with a date column"}); return }
+        }
+        rows, err := database.Pool.Query(ctx, `
+            SELECT to_char(date_trunc($2, txn_date::timestamp), 'YYYY-MM-DD') AS period,
+                   COALESCE(SUM(amount),0)::float8,
+                   COALESCE(SUM(amount) FILTER (WHERE kind='sale'),0)::float8,
+                   COALESCE(SUM(ABS(amount)) FILTER (WHERE kind='return'),0)::float8,
+                   COUNT(*)::int, COUNT(DISTINCT lower(btrim(bill_id)))::int
+            FROM sales_transactions
+            WHERE sales_metric_id=$1 AND txn_date IS NOT NULL
+              AND ($3::date IS NULL OR txn_date >= $3::date)
+              AND ($4::date IS NULL OR txn_date <= $4::date)
+            GROUP BY 1 ORDER BY 1`, metricsID, trunc, from, to)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []TimeBucket{}
+        for rows.Next() {
+            var b TimeBucket
+            if err := rows.Scan(&b.Period, &b.TotalSales, &b.GrossSales, &b.Returns, &b.BillRows, &b.UniqueBills); err != nil { continue }
+            b.NetSales = round2(b.GrossSales - b.Returns)
+            b.TotalSales, b.GrossSales, b.Returns = round2(b.TotalSales), round2(b.GrossSales), round2(b.Returns)
+            out = append(out, b)
+        }
+        c.JSON(http.StatusOK, gin.H{"sales_metrics_id": metricsID, "granularity": trunc, "items": out})
+    }
+}
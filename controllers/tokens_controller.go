@@ -2,69 +2,113 @@ package controllers
 
 import (
     "context"
+    "encoding/json"
     "net/http"
+    "strconv"
     "time"
 
     "github.com/gin-gonic/gin"
     "scalingwolf-ai/backend/database"
 )
 
-type SetPlanRequest struct {
-    Points    int  `json:"points"`          // 1 point = 10,000 tokens; max 5 points
-    ResetUsed bool `json:"reset_used"`      // optional: reset usage to 0
+// FeatureUsage is the current period's usage for one feature.
+type FeatureUsage struct {
+    Feature         string `json:"feature"`
+    Calls           int64  `json:"calls"`
+    PromptTokens    int64  `json:"prompt_tokens"`
+    OutputTokens    int64  `json:"output_tokens"`
+    EmbeddingTokens int64  `json:"embedding_tokens"`
+    TotalTokens     int64  `json:"total_tokens"`
 }
 
+type UsagePeriod struct {
+    PeriodStart time.Time `json:"period_start"`
+    PeriodEnd   time.Time `json:"period_end"`
+    TokenQuota  int64     `json:"token_quota"`
+    TokenUsed   int64     `json:"token_used"`
+}
+
+// TokensUsage reports the current month's quota and usage, broken down by
+// feature, plus closed periods. Query: history (number of past periods, default 6, max 24).
 func TokensUsage() gin.HandlerFunc {
     return func(c *gin.Context) {
         uid := c.GetInt64("user_id")
+        historyN, _ := strconv.Atoi(c.DefaultQuery("history", "6"))
+        if historyN < 0 || historyN > 24 { historyN = 6 }
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
-        var quota, used int64
-        err := database.Pool.QueryRow(ctx, `SELECT token_quota::bigint, token_used::bigint FROM token_quotas WHERE user_id=$1`, uid).Scan(&quota, &used)
-        if err != nil {
-            // default: 5 points = 50k
-            quota = 50000
-            used = 0
+        q, err := loadQuota(ctx, uid)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        var calls int64
+        _ = database.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM token_usage_ledger WHERE user_id=$1 AND created_at >= $2`, uid, q.PeriodStart).Scan(&calls)
+        byFeature := []FeatureUsage{}
+        rows, err := database.Pool.Query(ctx, `
+            SELECT feature, COUNT(*),
+                COALESCE(SUM(prompt_tokens) FILTER (WHERE kind <> 'embed'),0)::bigint,
+                COALESCE(SUM(output_tokens),0)::bigint,
+                COALESCE(SUM(total_tokens) FILTER (WHERE kind = 'embed'),0)::bigint,
+                COALESCE(SUM(total_tokens),0)::bigint
+            FROM token_usage_ledger WHERE user_id=$1 AND created_at >= $2
+            GROUP BY feature ORDER BY 6 DESC`, uid, q.PeriodStart)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        for rows.Next() {
+            var f FeatureUsage
+            if err := rows.Scan(&f.Feature, &f.Calls, &f.PromptTokens, &f.OutputTokens, &f.EmbeddingTokens, &f.TotalTokens); err == nil { byFeature = append(byFeature, f) }
         }
-        remaining := quota - used
+        rows.Close()
+        history := []UsagePeriod{}
+        rows, err = database.Pool.Query(ctx, `SELECT period_start, period_end, token_quota, token_used FROM token_usage_periods
+            WHERE user_id=$1 ORDER BY period_start DESC LIMIT $2`, uid, historyN)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        for rows.Next() {
+            var p UsagePeriod
+            if err := rows.Scan(&p.PeriodStart, &p.PeriodEnd, &p.TokenQuota, &p.TokenUsed); err == nil { history = append(history, p) }
+        }
+        rows.Close()
+        reserved := reservedTokens(ctx, uid)
+        remaining := q.Quota - q.Used
         if remaining < 0 { remaining = 0 }
-        points := quota / 10000
-        pointsUsed := used / 10000
-        pointsRemaining := remaining / 10000
         c.JSON(http.StatusOK, gin.H{
-            "points": points,
-            "token_quota": quota,
-            "token_used": used,
+            "points": q.Quota / tokensPerPoint,
+            "token_quota": q.Quota,
+            "token_used": q.Used,
+            "token_reserved": reserved,
             "remaining": remaining,
-            "points_used": pointsUsed,
-            "points_remaining": pointsRemaining,
+            "points_used": q.Used / tokensPerPoint,
+            "points_remaining": remaining / tokensPerPoint,
+            "plan": gin.H{"code": q.PlanCode, "name": q.PlanName, "monthly_points": q.PlanPoints, "features": q.Features},
+            "period": gin.H{"start": q.PeriodStart, "end": q.PeriodEnd, "calls": calls},
+            "by_feature": byFeature,
+            "history": history,
         })
     }
 }
 
-func TokensSetPlan() gin.HandlerFunc {
+type Plan struct {
+    Code          string          `json:"code"`
+    Name          string          `json:"name"`
+    MonthlyPoints int             `json:"monthly_points"`
+    MonthlyTokens int64           `json:"monthly_tokens"`
+    Features      json.RawMessage `json:"features"`
+}
+
+// ListPlans returns the plan catalogue.
+func ListPlans() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
-        var req SetPlanRequest
-        if err := c.ShouldBindJSON(&req); err != nil || req.Points <= 0 {
-            c.JSON(http.StatusBadRequest, gin.H{"error":"invalid body"}); return
-        }
-        if req.Points > 5 { req.Points = 5 }
-        quota := int64(req.Points) * 10000
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
-        if req.ResetUsed {
-            _, err := database.Pool.Exec(ctx, `INSERT INTO token_quotas(user_id, token_quota, token_used, updated_at)
-                VALUES($1,$2,0,now())
-                ON CONFLICT (user_id) DO UPDATE SET token_quota=EXCLUDED.token_quota, token_used=0, updated_at=now()`, uid, quota)
-            if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
-        } else {
-            _, err := database.Pool.Exec(ctx, `INSERT INTO token_quotas(user_id, token_quota, updated_at)
-                VALUES($1,$2,now())
-                ON CONFLICT (user_id) DO UPDATE SET token_quota=EXCLUDED.token_quota, updated_at=now()`, uid, quota)
-            if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        rows, err := database.Pool.Query(ctx, `SELECT code, name, monthly_points, features::text FROM plans ORDER BY monthly_points`)
+        if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db error"}); return }
+        defer rows.Close()
+        out := []Plan{}
+        for rows.Next() {
+            var p Plan
+            var features string
+            if err := rows.Scan(&p.Code, &p.Name, &p.MonthlyPoints, &features); err != nil { continue }
+            p.MonthlyTokens = int64(p.MonthlyPoints) * tokensPerPoint
+            p.Features = json.RawMessage(features)
+            out = append(out, p)
         }
-        c.JSON(http.StatusOK, gin.H{"status":"ok", "points": req.Points, "token_quota": quota})
+        c.JSON(http.StatusOK, gin.H{"items": out})
     }
 }
-
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v5"
+
+    "scalingwolf-ai/backend/config"
+    "scalingwolf-ai/backend/database"
+    "scalingwolf-ai/backend/ingestion"
+    "scalingwolf-ai/backend/jobs"
+    "scalingwolf-ai/backend/utils"
+)
+
+// pendingUploadTTL is how long a previewed upload waits for confirmation.
+const pendingUploadTTL = time.Hour
+
+// How much of the file a preview shows.
+const (
+    previewRawRows    = 10 // rows from the top of the sheet, to pick a header row
+    previewSampleRows = 20 // cleaned records under the detected header
+    profileRows       = 500
+)
+
+// columnProfile describes one column so the user can tell which holds the
+// sales amount and which the bill number.
+type columnProfile struct {
+    Name         string   `json:"name"`
+    Filled       int      `json:"filled"`
+    Distinct     int      `json:"distinct"`
+    NumericRatio float64  `json:"numeric_ratio"`
+    Samples      []string `json:"samples"`
+}
+
+// UploadPreview is the first step of a reviewed upload: it detects the header
+// row and columns (cache -> AI -> heuristic) and returns them with the top of
+// the sheet, every column's profile and a sample of cleaned rows, plus an
+// upload_token for UploadConfirm. Nothing is computed or cached yet.
+func UploadPreview(cfg config.Config) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        fileName, ext, buf, ok := readUploadFile(c)
+        if !ok {
+            return
+        }
+        sheets, err := readSheets(buf, ext)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        sheet, sheetScores, err := pickSheet(sheets, c.PostForm("sheet"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        rows := sheet.Rows
+        if len(rows) == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "no rows found in file"})
+            return
+        }
+
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        det, err := salesDetector(cfg, meterFor(cfg, uid, orgID), orgID).Detect(ctx, rows)
+        if err != nil {
+            // Still show the keyword guess so the user has something to correct
+            det = ingestion.DetectHeuristic(rows)
+            det.Note = err.Error()
+        }
+        headers := ingestion.NormalizeHeaders(rows, det.HeaderRow)
+        cols := ingestion.Columns{HeaderRow: det.HeaderRow, Headers: headers, Sales: ingestion.FindColumn(headers, det.Sales), Bill: ingestion.FindColumn(headers, det.Bill)}
+        rules := ingestion.DefaultRules()
+        if det.Rules != nil {
+            rules = *det.Rules
+        }
+        records := ingestion.Records(rows, det.HeaderRow, headers)
+        sampleRules := rules
+        if cols.Sales != "" && cols.Bill != "" {
+            cols.Date = ingestion.ResolveDateColumn(rows, det.HeaderRow, headers, det.Date, cols.Sales, cols.Bill)
+            cols.Format = ingestion.ColumnNumberFormat(records, cols.Sales)
+        } else {
+            // Without sales and bill columns only the steps needing neither apply
+            sampleRules.Enabled = []string{ingestion.ReasonBlank, ingestion.ReasonTotalishSecondColumn}
+        }
+        records, _ = ingestion.Clean(records, cols, sampleRules.Cleaners()...)
+        sample := records
+        if len(sample) > previewSampleRows {
+            sample = sample[:previewSampleRows]
+        }
+
+        token, tokenHash, err := utils.NewOpaqueToken()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+            return
+        }
+        _, _ = database.Pool.Exec(ctx, `DELETE FROM pending_uploads WHERE user_id=$1 AND expires_at <= now()`, uid)
+        _, err = database.Pool.Exec(ctx, `INSERT INTO pending_uploads(user_id, org_id, token_hash, file_name, ext, sheet, content, header_row, sales_column, bill_column, date_column, expires_at)
+            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`,
+            uid, orgID, tokenHash, fileName, ext, sheet.Name, buf, det.HeaderRow, cols.Sales, cols.Bill, cols.Date, time.Now().Add(pendingUploadTTL))
+        if err != nil {
+            log.Printf("upload preview insert error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert error"})
+            return
+        }
+
+        resp := gin.H{
+            "upload_token": token,
+            "expires_in":   int64(pendingUploadTTL / time.Second),
+            "file_name":    fileName,
+            "sheet":        sheet.Name,
+            "header_row":   det.HeaderRow,
+            "headers":      headers,
+            "sales_column": cols.Sales,
+            "bill_column":  cols.Bill,
+            "date_column":  cols.Date,
+            "detected_by":  det.Source,
+            "ai_used":      det.Source == "ai",
+            "ai_message":   det.Note,
+            "top_rows":     firstNRows(rows, previewRawRows),
+            "columns":      profileColumns(records, headers),
+            "sample_rows":  sample,
+            "rules":        rules,
+        }
+        if sheet.Dialect != nil {
+            resp["csv_dialect"] = sheet.Dialect
+        }
+        if len(sheetScores) > 1 {
+            resp["sheets"] = sheetScores
+        }
+        c.JSON(http.StatusOK, resp)
+    }
+}
+
+// UploadConfirm is the second step: it takes the upload_token from
+// UploadPreview and optional overrides of header_row, sales_column,
+// bill_column, date_column and cleaning rules, checks them against the
+// sheet and queues the analysis like UploadAnalyze. The confirmed mapping is
+// saved for the org, under mapping_name if given, with the rules.
+func UploadConfirm() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req struct {
+            UploadToken string  `json:"upload_token"`
+            HeaderRow   *int    `json:"header_row"`
+            SalesColumn *string `json:"sales_column"`
+            BillColumn  *string `json:"bill_column"`
+            DateColumn  *string `json:"date_column"`
+            MappingName string  `json:"mapping_name"`
+            // Rules are decoded over the saved mapping's rules or the defaults
+            Rules json.RawMessage `json:"rules"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil || req.UploadToken == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "upload_token required"})
+            return
+        }
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+
+        var id int64
+        var p uploadParams
+        var content []byte
+        var m confirmedMapping
+        err := database.Pool.QueryRow(ctx, `SELECT id, file_name, ext, sheet, content, header_row, sales_column, bill_column, date_column
+            FROM pending_uploads WHERE token_hash=$1 AND user_id=$2 AND org_id=$3 AND expires_at > now()`,
+            utils.HashToken(req.UploadToken), uid, orgID).
+            Scan(&id, &p.FileName, &p.Ext, &p.Sheet, &content, &m.HeaderRow, &m.Sales, &m.Bill, &m.Date)
+        if errors.Is(err, pgx.ErrNoRows) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "upload not found or expired; upload the file again"})
+            return
+        }
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+            return
+        }
+
+        sheets, err := readSheets(content, p.Ext)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        sheet, _, err := pickSheet(sheets, p.Sheet)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if req.HeaderRow != nil {
+            m.HeaderRow = *req.HeaderRow
+        }
+        if m.HeaderRow < 0 || m.HeaderRow >= len(sheet.Rows) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "header_row out of range"})
+            return
+        }
+        headers := ingestion.NormalizeHeaders(sheet.Rows, m.HeaderRow)
+        if req.SalesColumn != nil {
+            m.Sales = *req.SalesColumn
+        }
+        if req.BillColumn != nil {
+            m.Bill = *req.BillColumn
+        }
+        if req.DateColumn != nil {
+            m.Date = *req.DateColumn
+        }
+        // Overrides name columns of the chosen header row; match them exactly
+        // (case-insensitive) so a typo is reported, not guessed at.
+        for _, col := range []struct {
+            field    string
+            name     *string
+            required bool
+        }{
+            {"sales_column", &m.Sales, true},
+            {"bill_column", &m.Bill, true},
+            {"date_column", &m.Date, false},
+        } {
+            if strings.TrimSpace(*col.name) == "" && !col.required {
+                continue
+            }
+            h := exactHeader(headers, *col.name)
+            if h == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": col.field + " not found in header row", "column": *col.name, "headers": headers})
+                return
+            }
+            *col.name = h
+        }
+        if m.Sales == m.Bill {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "sales_column and bill_column must differ"})
+            return
+        }
+        if saved, ok := mappingRules(ctx, orgID, sheet.Rows[m.HeaderRow]); ok {
+            m.Rules = &saved
+        }
+        if len(req.Rules) > 0 && string(req.Rules) != "null" {
+            rules := ingestion.DefaultRules()
+            if m.Rules != nil {
+                rules = *m.Rules
+            }
+            if err := json.Unmarshal(req.Rules, &rules); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rules"})
+                return
+            }
+            if msg := checkRules(&rules, headers); msg != "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+                return
+            }
+            m.Rules = &rules
+        }
+
+        // Single use: whoever deletes the row gets to queue the job
+        res, err := database.Pool.Exec(ctx, `DELETE FROM pending_uploads WHERE id=$1`, id)
+        if err != nil || res.RowsAffected() == 0 {
+            c.JSON(http.StatusConflict, gin.H{"error": "upload already confirmed"})
+            return
+        }
+        m.Name = strings.TrimSpace(req.MappingName)
+        p.Mapping = &m
+        jobID, err := jobs.Enqueue(ctx, uid, orgID, jobUploadAnalyze, p, content)
+        if err != nil {
+            log.Printf("upload confirm enqueue error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert error"})
+            return
+        }
+        c.JSON(http.StatusAccepted, gin.H{"job_id": jobID, "status": jobs.StatusQueued, "mapping": m})
+    }
+}
+
+// exactHeader returns the header spelled as in headers for a case-insensitive
+// match of name, or "" when there is none.
+func exactHeader(headers []string, name string) string {
+    name = strings.TrimSpace(name)
+    for _, h := range headers {
+        if strings.EqualFold(h, name) {
+            return h
+        }
+    }
+    return ""
+}
+
+// profileColumns summarises up to profileRows records per header.
+func profileColumns(records []ingestion.Record, headers []string) []columnProfile {
+    if len(records) > profileRows {
+        records = records[:profileRows]
+    }
+    out := make([]columnProfile, 0, len(headers))
+    for _, h := range headers {
+        p := columnProfile{Name: h, Samples: []string{}}
+        seen := map[string]struct{}{}
+        numeric := 0
+        for _, r := range records {
+            v := strings.TrimSpace(r.Values[h])
+            if v == "" {
+                continue
+            }
+            p.Filled++
+            if _, ok := seen[v]; !ok {
+                seen[v] = struct{}{}
+                if len(p.Samples) < 3 {
+                    p.Samples = append(p.Samples, v)
+                }
+            }
+            if !math.IsNaN(toNumeric(v)) {
+                numeric++
+            }
+        }
+        p.Distinct = len(seen)
+        if p.Filled > 0 {
+            p.NumericRatio = round2(float64(numeric) / float64(p.Filled))
+        }
+        out = append(out, p)
+    }
+    return out
+}
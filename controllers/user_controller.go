@@ -12,16 +12,19 @@ import (
 
 func Me() gin.HandlerFunc {
     return func(c *gin.Context) {
-        uid := c.GetInt64("user_id")
+        uid, orgID := c.GetInt64("user_id"), c.GetInt64("org_id")
         ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
         defer cancel()
         var u models.User
-        err := database.Pool.QueryRow(ctx, `SELECT id,name,business_name,email,phone,is_whatsapp_verified,industry_type,sub_industry,COALESCE(core_processes,'{}'::text[])::text[], monthly_revenue, employees, goal_amount, goal_years, created_at FROM users WHERE id=$1`, uid).
-            Scan(&u.ID, &u.Name, &u.BusinessName, &u.Email, &u.Phone, &u.IsWhatsAppVerified, &u.IndustryType, &u.SubIndustry, &u.CoreProcesses, &u.MonthlyRevenue, &u.Employees, &u.GoalAmount, &u.GoalYears, &u.CreatedAt)
+        // Company profile fields come from the active organization.
+        err := database.Pool.QueryRow(ctx, `SELECT u.id,u.name,o.name,u.email,u.phone,u.is_whatsapp_verified,o.industry_type,o.sub_industry,COALESCE(o.core_processes,'{}'::text[])::text[], o.monthly_revenue::float8, o.employees, o.goal_amount::float8, o.goal_years, u.role, u.created_at
+FROM users u JOIN organizations o ON o.id=$2 WHERE u.id=$1`, uid, orgID).
+            Scan(&u.ID, &u.Name, &u.BusinessName, &u.Email, &u.Phone, &u.IsWhatsAppVerified, &u.IndustryType, &u.SubIndustry, &u.CoreProcesses, &u.MonthlyRevenue, &u.Employees, &u.GoalAmount, &u.GoalYears, &u.Role, &u.CreatedAt)
         if err != nil {
             c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
             return
         }
+        u.OrgID, u.OrgRole = orgID, c.GetString("org_role")
         c.JSON(http.StatusOK, u)
     }
 }
@@ -0,0 +1,248 @@
+package database
+
+import (
+    "context"
+    "crypto/sha256"
+    "embed"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io/fs"
+    "regexp"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrations run, so
+// several instances booting at once apply each migration exactly once.
+const migrationLockKey int64 = 727274101
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its up and down SQL.
+type Migration struct {
+    Version int64
+    Name    string
+    Up      string
+    Down    string
+}
+
+// Checksum identifies the up script so edits to applied migrations are visible.
+func (m Migration) Checksum() string {
+    sum := sha256.Sum256([]byte(m.Up))
+    return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus describes a known migration and whether it has been applied.
+type MigrationStatus struct {
+    Version   int64      `json:"version"`
+    Name      string     `json:"name"`
+    AppliedAt *time.Time `json:"applied_at"`
+    Modified  bool       `json:"modified"` // applied checksum differs from the embedded file
+}
+
+// LoadMigrations returns the embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+    entries, err := fs.ReadDir(migrationFiles, "migrations")
+    if err != nil {
+        return nil, err
+    }
+    byVersion := map[int64]*Migration{}
+    for _, e := range entries {
+        m := migrationName.FindStringSubmatch(e.Name())
+        if m == nil {
+            return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+        }
+        v, _ := strconv.ParseInt(m[1], 10, 64)
+        b, err := migrationFiles.ReadFile("migrations/" + e.Name())
+        if err != nil {
+            return nil, err
+        }
+        mig, ok := byVersion[v]
+        if !ok {
+            mig = &Migration{Version: v, Name: m[2]}
+            byVersion[v] = mig
+        } else if mig.Name != m[2] {
+            return nil, fmt.Errorf("migration %d has conflicting names %q and %q", v, mig.Name, m[2])
+        }
+        if m[3] == "up" {
+            mig.Up = string(b)
+        } else {
+            mig.Down = string(b)
+        }
+    }
+    out := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" || m.Down == "" {
+            return nil, fmt.Errorf("migration %d_%s needs both up and down files", m.Version, m.Name)
+        }
+        out = append(out, *m)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+    return out, nil
+}
+
+// MigrateUp applies every pending migration in order. Each migration runs in
+// its own transaction; the first failure stops the run and is returned.
+func MigrateUp(ctx context.Context) error {
+    migs, err := LoadMigrations()
+    if err != nil {
+        return err
+    }
+    return withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+        applied, err := appliedVersions(ctx, conn)
+        if err != nil {
+            return err
+        }
+        for _, m := range migs {
+            if _, ok := applied[m.Version]; ok {
+                continue
+            }
+            if err := applyMigration(ctx, conn, m, true); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first.
+func MigrateDown(ctx context.Context, steps int) error {
+    if steps <= 0 {
+        return errors.New("steps must be > 0")
+    }
+    migs, err := LoadMigrations()
+    if err != nil {
+        return err
+    }
+    return withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+        applied, err := appliedVersions(ctx, conn)
+        if err != nil {
+            return err
+        }
+        for i := len(migs) - 1; i >= 0 && steps > 0; i-- {
+            m := migs[i]
+            if _, ok := applied[m.Version]; !ok {
+                continue
+            }
+            if err := applyMigration(ctx, conn, m, false); err != nil {
+                return err
+            }
+            steps--
+        }
+        return nil
+    })
+}
+
+// Status lists every embedded migration with its applied time, if any.
+func Status(ctx context.Context) ([]MigrationStatus, error) {
+    migs, err := LoadMigrations()
+    if err != nil {
+        return nil, err
+    }
+    conn, err := Pool.Acquire(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Release()
+    if err := ensureMigrationsTable(ctx, conn); err != nil {
+        return nil, err
+    }
+    applied, err := appliedVersions(ctx, conn)
+    if err != nil {
+        return nil, err
+    }
+    out := make([]MigrationStatus, 0, len(migs))
+    for _, m := range migs {
+        st := MigrationStatus{Version: m.Version, Name: m.Name}
+        if a, ok := applied[m.Version]; ok {
+            t := a.appliedAt
+            st.AppliedAt = &t
+            st.Modified = a.checksum != m.Checksum()
+        }
+        out = append(out, st)
+    }
+    return out, nil
+}
+
+type appliedMigration struct {
+    appliedAt time.Time
+    checksum  string
+}
+
+func withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+    if Pool == nil {
+        return errors.New("database not connected")
+    }
+    conn, err := Pool.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Release()
+    if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+        return fmt.Errorf("acquire migration lock: %w", err)
+    }
+    defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+    if err := ensureMigrationsTable(ctx, conn); err != nil {
+        return err
+    }
+    return fn(conn)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *pgxpool.Conn) error {
+    _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name TEXT NOT NULL,
+        checksum TEXT NOT NULL,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    )`)
+    return err
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]appliedMigration, error) {
+    rows, err := conn.Query(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[int64]appliedMigration{}
+    for rows.Next() {
+        var v int64
+        var a appliedMigration
+        if err := rows.Scan(&v, &a.checksum, &a.appliedAt); err != nil {
+            return nil, err
+        }
+        out[v] = a
+    }
+    return out, rows.Err()
+}
+
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, up bool) error {
+    tx, err := conn.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+    dir, script := "up", m.Up
+    if !up {
+        dir, script = "down", m.Down
+    }
+    if _, err := tx.Exec(ctx, script); err != nil {
+        return fmt.Errorf("migration %d_%s (%s): %w", m.Version, m.Name, dir, err)
+    }
+    if up {
+        _, err = tx.Exec(ctx, `INSERT INTO schema_migrations(version, name, checksum) VALUES($1,$2,$3)`, m.Version, m.Name, m.Checksum())
+    } else {
+        _, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+    }
+    if err != nil {
+        return fmt.Errorf("record migration %d_%s: %w", m.Version, m.Name, err)
+    }
+    return tx.Commit(ctx)
+}
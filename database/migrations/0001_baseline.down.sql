@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS token_quotas;
+DROP TABLE IF EXISTS bep_results;
+DROP TABLE IF EXISTS column_mappings;
+DROP TABLE IF EXISTS rag_documents;
+DROP TABLE IF EXISTS sales_metrics;
+DROP TABLE IF EXISTS chat_messages;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,14 @@
+-- Cleaned rows behind each file upload, so past uploads can be drilled into.
+CREATE TABLE IF NOT EXISTS sales_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    sales_metric_id BIGINT NOT NULL REFERENCES sales_metrics(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL,
+    line_no INT NOT NULL, -- 1-based position among the rows used for the metrics
+    bill_id TEXT NOT NULL,
+    amount NUMERIC,
+    txn_date DATE,
+    raw JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS sales_transactions_metric_idx ON sales_transactions(sales_metric_id, line_no);
+CREATE INDEX IF NOT EXISTS sales_transactions_user_date_idx ON sales_transactions(user_id, txn_date);
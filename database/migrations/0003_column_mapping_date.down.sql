@@ -0,0 +1 @@
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS date_column;
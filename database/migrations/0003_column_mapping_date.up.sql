@@ -0,0 +1 @@
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS date_column TEXT;
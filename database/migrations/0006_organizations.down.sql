@@ -0,0 +1,48 @@
+ALTER TABLE column_mappings DROP CONSTRAINT IF EXISTS column_mappings_org_id_signature_key;
+-- Rows other members authored would collide with the owner's mapping.
+DELETE FROM column_mappings a USING column_mappings b
+WHERE a.user_id = b.user_id AND a.signature = b.signature AND a.id < b.id;
+ALTER TABLE column_mappings ADD CONSTRAINT column_mappings_user_id_signature_key UNIQUE (user_id, signature);
+
+DROP INDEX IF EXISTS chats_org_user_idx;
+DROP INDEX IF EXISTS rag_documents_org_type_idx;
+DROP INDEX IF EXISTS rag_documents_org_id_idx;
+DROP INDEX IF EXISTS bep_results_org_id_idx;
+DROP INDEX IF EXISTS sales_transactions_org_date_idx;
+DROP INDEX IF EXISTS sales_metrics_org_id_idx;
+
+ALTER TABLE chats DROP COLUMN IF EXISTS org_id;
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS org_id;
+ALTER TABLE rag_documents DROP COLUMN IF EXISTS org_id;
+ALTER TABLE bep_results DROP COLUMN IF EXISTS org_id;
+ALTER TABLE sales_transactions DROP COLUMN IF EXISTS org_id;
+ALTER TABLE sales_metrics DROP COLUMN IF EXISTS org_id;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS business_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS industry_type TEXT,
+    ADD COLUMN IF NOT EXISTS sub_industry TEXT,
+    ADD COLUMN IF NOT EXISTS core_processes TEXT[],
+    ADD COLUMN IF NOT EXISTS monthly_revenue NUMERIC,
+    ADD COLUMN IF NOT EXISTS employees INT,
+    ADD COLUMN IF NOT EXISTS goal_amount NUMERIC,
+    ADD COLUMN IF NOT EXISTS goal_years INT;
+
+-- Each user gets the profile of the organization they had active.
+UPDATE users u SET
+    business_name = o.name,
+    industry_type = o.industry_type,
+    sub_industry = o.sub_industry,
+    core_processes = o.core_processes,
+    monthly_revenue = o.monthly_revenue,
+    employees = o.employees,
+    goal_amount = o.goal_amount,
+    goal_years = o.goal_years
+FROM organizations o WHERE o.id = u.active_org_id;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS org_id;
+ALTER TABLE users DROP COLUMN IF EXISTS active_org_id;
+
+DROP TABLE IF EXISTS org_invites;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
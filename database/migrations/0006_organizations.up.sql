@@ -0,0 +1,104 @@
+-- Organizations own company data; users reach it through org_members.
+-- Every existing user gets a personal organization holding their profile and
+-- all of their rows, so nothing changes for single-user accounts.
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '', -- business name
+    industry_type TEXT,
+    sub_industry TEXT,
+    core_processes TEXT[],
+    monthly_revenue NUMERIC,
+    employees INT,
+    goal_amount NUMERIC,
+    goal_years INT,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner','admin','analyst','viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS org_members_user_id_idx ON org_members(user_id);
+
+CREATE TABLE IF NOT EXISTS org_invites (
+    id BIGSERIAL PRIMARY KEY,
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('owner','admin','analyst','viewer')),
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by BIGINT REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS org_invites_org_id_idx ON org_invites(org_id) WHERE accepted_at IS NULL;
+
+-- Remembered across logins; switched with POST /api/orgs/switch.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active_org_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL;
+
+-- Personal organization per existing user. created_by is unique at this
+-- point, which is what links each new org back to its owner.
+INSERT INTO organizations(name, industry_type, sub_industry, core_processes, monthly_revenue, employees, goal_amount, goal_years, created_by, created_at)
+SELECT business_name, industry_type, sub_industry, core_processes, monthly_revenue, employees, goal_amount, goal_years, id, created_at
+FROM users;
+INSERT INTO org_members(org_id, user_id, role)
+SELECT id, created_by, 'owner' FROM organizations WHERE created_by IS NOT NULL;
+UPDATE users u SET active_org_id = o.id FROM organizations o WHERE o.created_by = u.id;
+UPDATE sessions s SET org_id = u.active_org_id FROM users u WHERE u.id = s.user_id;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS business_name,
+    DROP COLUMN IF EXISTS industry_type,
+    DROP COLUMN IF EXISTS sub_industry,
+    DROP COLUMN IF EXISTS core_processes,
+    DROP COLUMN IF EXISTS monthly_revenue,
+    DROP COLUMN IF EXISTS employees,
+    DROP COLUMN IF EXISTS goal_amount,
+    DROP COLUMN IF EXISTS goal_years;
+
+-- Company data moves to org scope; user_id stays as the author.
+ALTER TABLE sales_metrics ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE sales_transactions ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE bep_results ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE rag_documents ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+
+UPDATE sales_metrics t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+UPDATE sales_transactions t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+UPDATE bep_results t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+UPDATE rag_documents t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+UPDATE column_mappings t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+UPDATE chats t SET org_id = u.active_org_id FROM users u WHERE u.id = t.user_id;
+
+-- Rows whose user no longer exists cannot be attributed to any org.
+DELETE FROM sales_metrics WHERE org_id IS NULL;
+DELETE FROM sales_transactions WHERE org_id IS NULL;
+DELETE FROM bep_results WHERE org_id IS NULL;
+DELETE FROM rag_documents WHERE org_id IS NULL;
+DELETE FROM column_mappings WHERE org_id IS NULL;
+DELETE FROM chats WHERE org_id IS NULL;
+
+ALTER TABLE sales_metrics ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE sales_transactions ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE bep_results ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE rag_documents ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE column_mappings ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE chats ALTER COLUMN org_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS sales_metrics_org_id_idx ON sales_metrics(org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS sales_transactions_org_date_idx ON sales_transactions(org_id, txn_date);
+CREATE INDEX IF NOT EXISTS bep_results_org_id_idx ON bep_results(org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS rag_documents_org_id_idx ON rag_documents(org_id);
+CREATE INDEX IF NOT EXISTS rag_documents_org_type_idx ON rag_documents(org_id, (metadata->>'type'));
+CREATE INDEX IF NOT EXISTS chats_org_user_idx ON chats(org_id, user_id);
+
+-- Column mappings are shared by everyone uploading for the org.
+ALTER TABLE column_mappings DROP CONSTRAINT IF EXISTS column_mappings_user_id_signature_key;
+ALTER TABLE column_mappings ADD CONSTRAINT column_mappings_org_id_signature_key UNIQUE (org_id, signature);
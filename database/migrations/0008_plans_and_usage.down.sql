@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS token_usage_ledger;
+DROP TABLE IF EXISTS token_usage_periods;
+ALTER TABLE token_quotas DROP COLUMN IF EXISTS period_end;
+ALTER TABLE token_quotas DROP COLUMN IF EXISTS period_start;
+ALTER TABLE token_quotas DROP COLUMN IF EXISTS plan_id;
+DROP TABLE IF EXISTS plans;
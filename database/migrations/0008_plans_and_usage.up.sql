@@ -0,0 +1,48 @@
+-- Plan catalogue. 1 point = 10,000 tokens per calendar month.
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    monthly_points INT NOT NULL CHECK (monthly_points > 0),
+    features JSONB NOT NULL DEFAULT '[]'::jsonb,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO plans(code, name, monthly_points, features) VALUES
+    ('free', 'Free', 5, '["chat","file_analysis","bep"]'),
+    ('starter', 'Starter', 20, '["chat","file_analysis","bep","rag"]'),
+    ('growth', 'Growth', 50, '["chat","file_analysis","bep","rag","organizations"]'),
+    ('pro', 'Pro', 150, '["chat","file_analysis","bep","rag","organizations","priority_support"]')
+ON CONFLICT (code) DO NOTHING;
+
+-- token_quotas now describes the current calendar-month period. token_quota
+-- is the effective limit: the plan's points unless an admin overrode it.
+ALTER TABLE token_quotas ADD COLUMN IF NOT EXISTS plan_id INT REFERENCES plans(id);
+ALTER TABLE token_quotas ADD COLUMN IF NOT EXISTS period_start TIMESTAMPTZ NOT NULL DEFAULT date_trunc('month', now());
+ALTER TABLE token_quotas ADD COLUMN IF NOT EXISTS period_end TIMESTAMPTZ NOT NULL DEFAULT date_trunc('month', now()) + interval '1 month';
+UPDATE token_quotas SET plan_id = (SELECT id FROM plans WHERE code='free') WHERE plan_id IS NULL;
+ALTER TABLE token_quotas ALTER COLUMN plan_id SET NOT NULL;
+
+-- Closed periods, written when a period rolls over.
+CREATE TABLE IF NOT EXISTS token_usage_periods (
+    user_id BIGINT NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    plan_id INT REFERENCES plans(id),
+    token_quota BIGINT NOT NULL,
+    token_used BIGINT NOT NULL,
+    PRIMARY KEY (user_id, period_start)
+);
+
+-- One row per metered model call.
+CREATE TABLE IF NOT EXISTS token_usage_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    org_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL,
+    feature TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    output_tokens BIGINT NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS token_usage_ledger_user_idx ON token_usage_ledger(user_id, created_at DESC);
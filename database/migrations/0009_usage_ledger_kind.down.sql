@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS token_usage_ledger_feature_idx;
+ALTER TABLE token_usage_ledger DROP COLUMN IF EXISTS estimated;
+ALTER TABLE token_usage_ledger DROP COLUMN IF EXISTS kind;
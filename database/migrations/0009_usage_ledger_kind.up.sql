@@ -0,0 +1,5 @@
+-- Every model call is metered now; embeddings report no usage from the API,
+-- so their token counts are estimated and flagged.
+ALTER TABLE token_usage_ledger ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'generate';
+ALTER TABLE token_usage_ledger ADD COLUMN IF NOT EXISTS estimated BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS token_usage_ledger_feature_idx ON token_usage_ledger(user_id, feature, created_at);
@@ -0,0 +1,13 @@
+-- Budget held by in-flight model calls. A call reserves an estimate before it
+-- starts and deletes its row when it settles; rows left behind by a crashed
+-- process stop counting once they expire.
+CREATE TABLE IF NOT EXISTS token_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL,
+    feature TEXT NOT NULL,
+    tokens BIGINT NOT NULL CHECK (tokens >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS token_reservations_user_idx ON token_reservations(user_id, expires_at);
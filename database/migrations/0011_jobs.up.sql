@@ -0,0 +1,24 @@
+-- Background work (file ingestion) claimed by workers with SKIP LOCKED.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued','running','succeeded','failed')),
+    params JSONB NOT NULL DEFAULT '{}'::jsonb,
+    -- Raw input such as an uploaded file; cleared once the job is finished.
+    payload BYTEA,
+    progress INT NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    progress_note TEXT NOT NULL DEFAULT '',
+    result JSONB,
+    error TEXT,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 3,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs(run_at, id) WHERE status IN ('queued','running');
+CREATE INDEX IF NOT EXISTS jobs_org_user_idx ON jobs(org_id, user_id, created_at DESC);
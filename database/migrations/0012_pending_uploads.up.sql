@@ -0,0 +1,20 @@
+-- Uploads awaiting the user's confirmation of header row and columns.
+-- Only the sha256 of the upload token is stored.
+CREATE TABLE IF NOT EXISTS pending_uploads (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    file_name TEXT NOT NULL,
+    ext TEXT NOT NULL,
+    sheet TEXT NOT NULL DEFAULT '',
+    content BYTEA NOT NULL,
+    -- Detected mapping, used for whatever the user does not override.
+    header_row INT NOT NULL,
+    sales_column TEXT NOT NULL DEFAULT '',
+    bill_column TEXT NOT NULL DEFAULT '',
+    date_column TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pending_uploads_user_idx ON pending_uploads(user_id, expires_at);
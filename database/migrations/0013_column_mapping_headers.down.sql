@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS column_mappings_org_header_key_idx;
+DELETE FROM column_mappings WHERE signature IS NULL;
+ALTER TABLE column_mappings ALTER COLUMN signature SET NOT NULL;
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS name;
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS headers;
+ALTER TABLE column_mappings DROP COLUMN IF EXISTS header_key;
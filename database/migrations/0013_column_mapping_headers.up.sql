@@ -0,0 +1,10 @@
+-- Mappings are matched by their normalized header set instead of a hash of
+-- the first rows, and can be named per data source. Rows saved before this
+-- have no header_key and no longer match uploads; they stay listable so
+-- they can be deleted.
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS header_key TEXT;
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS headers JSONB;
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE column_mappings ALTER COLUMN signature DROP NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS column_mappings_org_header_key_idx ON column_mappings(org_id, header_key);
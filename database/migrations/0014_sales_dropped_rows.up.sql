@@ -0,0 +1,12 @@
+-- Rows the cleaning pipeline left out of an upload's metrics, with the reason,
+-- so users can audit what was excluded.
+CREATE TABLE IF NOT EXISTS sales_dropped_rows (
+    id BIGSERIAL PRIMARY KEY,
+    sales_metric_id BIGINT NOT NULL REFERENCES sales_metrics(id) ON DELETE CASCADE,
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    line_no INT NOT NULL, -- 1-based row number in the uploaded sheet
+    reason TEXT NOT NULL,
+    raw JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS sales_dropped_rows_metric_idx ON sales_dropped_rows(sales_metric_id, line_no);
@@ -0,0 +1,3 @@
+-- Cleaning rules saved with a mapping and applied to uploads it matches.
+-- NULL means the default rules.
+ALTER TABLE column_mappings ADD COLUMN IF NOT EXISTS rules JSONB;
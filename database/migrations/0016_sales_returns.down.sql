@@ -0,0 +1,2 @@
+ALTER TABLE bep_results DROP COLUMN IF EXISTS revenue_basis;
+ALTER TABLE sales_transactions DROP COLUMN IF EXISTS kind;
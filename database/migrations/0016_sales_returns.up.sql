@@ -0,0 +1,4 @@
+-- Stored rows say whether they are a sale, a return or a cancellation, and
+-- BEP results record which revenue figure they were computed from.
+ALTER TABLE sales_transactions ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'sale';
+ALTER TABLE bep_results ADD COLUMN IF NOT EXISTS revenue_basis TEXT NOT NULL DEFAULT 'total';
@@ -0,0 +1 @@
+ALTER TABLE bep_results DROP COLUMN IF EXISTS bill_basis;
@@ -0,0 +1,2 @@
+-- Whether a BEP result averaged revenue over bill rows or unique bills.
+ALTER TABLE bep_results ADD COLUMN IF NOT EXISTS bill_basis TEXT NOT NULL DEFAULT 'rows';
@@ -0,0 +1,188 @@
+package ingestion
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+)
+
+// topBills is how many of the largest bills Bills lists.
+const topBills = 10
+
+// maxBucketSteps bounds the 1-2-5 steps billBuckets takes. About 1900 cover
+// the whole float64 range, from the smallest subnormal to the largest value.
+const maxBucketSteps = 2000
+
+// Bills aggregates the sale lines by bill number. Returns and cancellations
+// are left out, so Count matches Revenue.UniqueSaleBills and the values add
+// up to Revenue.GrossSales.
+type Bills struct {
+    Count        int          `json:"count"`
+    AverageValue float64      `json:"average_value"` // average order value
+    MedianValue  float64      `json:"median_value"`
+    MinValue     float64      `json:"min_value"`
+    MaxValue     float64      `json:"max_value"`
+    AverageItems float64      `json:"average_items"` // sale lines per bill
+    MedianItems  float64      `json:"median_items"`
+    Distribution []BillBucket `json:"distribution"`
+    Top          []BillTotal  `json:"top"`
+}
+
+// BillTotal is one bill's value and number of lines.
+type BillTotal struct {
+    BillID string  `json:"bill_id"`
+    Total  float64 `json:"total"`
+    Items  int     `json:"items"`
+}
+
+// BillBucket counts the bills whose value is in [Min, Max).
+type BillBucket struct {
+    Min   float64 `json:"min"`
+    Max   float64 `json:"max"`
+    Bills int     `json:"bills"`
+}
+
+// BillKey is the key under which bill numbers are counted as one bill, so
+// "inv-7" and "INV-7" are the same bill everywhere bills are counted.
+func BillKey(id string) string {
+    return strings.ToLower(strings.TrimSpace(id))
+}
+
+// aggregateBills groups the sale transactions by bill, ignoring case in
+// bill numbers. A bill whose lines add up past the float64 range fails with
+// ErrAmountOverflow.
+func aggregateBills(txns []Transaction) (Bills, error) {
+    at := map[string]int{}
+    var totals []BillTotal
+    for _, t := range txns {
+        if t.Kind != KindSale || t.BillID == "" {
+            continue
+        }
+        key := BillKey(t.BillID)
+        i, ok := at[key]
+        if !ok {
+            i = len(totals)
+            at[key] = i
+            totals = append(totals, BillTotal{BillID: t.BillID})
+        }
+        if t.Amount != nil {
+            totals[i].Total += *t.Amount
+        }
+        totals[i].Items++
+    }
+    b := Bills{Count: len(totals), Distribution: []BillBucket{}, Top: []BillTotal{}}
+    if len(totals) == 0 {
+        return b, nil
+    }
+
+    values := make([]float64, len(totals))
+    items := make([]float64, len(totals))
+    sum, lines := 0.0, 0
+    for i, t := range totals {
+        if !finite(t.Total) {
+            return Bills{}, fmt.Errorf("%w: bill %q", ErrAmountOverflow, t.BillID)
+        }
+        values[i] = t.Total
+        items[i] = float64(t.Items)
+        sum += t.Total
+        lines += t.Items
+    }
+    sort.Float64s(values)
+    sort.Float64s(items)
+    b.AverageValue = sum / float64(len(totals))
+    b.MedianValue = median(values)
+    b.MinValue, b.MaxValue = values[0], values[len(values)-1]
+    b.AverageItems = float64(lines) / float64(len(totals))
+    b.MedianItems = median(items)
+    b.Distribution = billBuckets(values)
+
+    sort.SliceStable(totals, func(i, j int) bool { return totals[i].Total > totals[j].Total })
+    if len(totals) > topBills {
+        totals = totals[:topBills]
+    }
+    b.Top = totals
+    return b, nil
+}
+
+// finite reports whether none of vs is infinite or NaN.
+func finite(vs ...float64) bool {
+    for _, v := range vs {
+        if math.IsInf(v, 0) || math.IsNaN(v) {
+            return false
+        }
+    }
+    return true
+}
+
+// median of sorted, non-empty values.
+func median(sorted []float64) float64 {
+    n := len(sorted)
+    if n%2 == 1 {
+        return sorted[n/2]
+    }
+    return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// billBuckets splits sorted bill values at 1-2-5 steps (100, 200, 500,
+// 1000, ...) from below the smallest value to above the largest, so the
+// buckets suit any currency and stay few on skewed data. The first bucket
+// starts at 0 to take zero-value bills.
+// Subnormal values, where the steps stop growing, end at maxBucketSteps.
+func billBuckets(sorted []float64) []BillBucket {
+    lo := 1.0
+    for _, v := range sorted {
+        if v > 0 {
+            lo = v
+            break
+        }
+    }
+    hi := sorted[len(sorted)-1]
+    edges := []float64{0}
+    for i, e := 0, step125(lo); i < maxBucketSteps; i, e = i+1, nextStep125(e) {
+        if e > edges[len(edges)-1] {
+            edges = append(edges, e)
+        }
+        if e > hi {
+            break
+        }
+    }
+    if len(edges) == 1 {
+        edges = append(edges, hi)
+    }
+    out := make([]BillBucket, 0, len(edges)-1)
+    for i := 0; i+1 < len(edges); i++ {
+        out = append(out, BillBucket{Min: edges[i], Max: edges[i+1]})
+    }
+    for _, v := range sorted {
+        i := sort.Search(len(out), func(i int) bool { return v < out[i].Max })
+        if i == len(out) {
+            i--
+        }
+        out[i].Bills++
+    }
+    return out
+}
+
+// step125 is the largest 1, 2 or 5 times a power of ten not above v (v > 0).
+func step125(v float64) float64 {
+    p := math.Pow(10, math.Floor(math.Log10(v)))
+    for _, m := range []float64{5, 2, 1} {
+        if m*p <= v {
+            return m * p
+        }
+    }
+    return p
+}
+
+func nextStep125(e float64) float64 {
+    p := math.Pow(10, math.Floor(math.Log10(e)+1e-9))
+    switch m := math.Round(e / p); m {
+    case 1:
+        return 2 * p
+    case 2:
+        return 5 * p
+    default:
+        return 10 * p
+    }
+}
@@ -0,0 +1,250 @@
+package ingestion
+
+import (
+    "math"
+    "regexp"
+    "strings"
+
+    "scalingwolf-ai/backend/utils"
+)
+
+// Why a row was left out of the metrics.
+const (
+    ReasonBlank                = "blank"
+    ReasonTotalishSecondColumn = "totalish_second_column"
+    ReasonSummaryRow           = "summary_row"
+    ReasonEmptyBill            = "empty_bill"
+    ReasonNonNumericAmount     = "non_numeric_amount"
+    ReasonExcluded             = "excluded" // matched one of Rules.Exclusions
+)
+
+// Record is one data row keyed by header. Line is its 1-based row number in
+// the sheet, so dropped rows can be found in the original file.
+type Record struct {
+    Line   int               `json:"line"`
+    Values map[string]string `json:"values"`
+}
+
+// Dropped is a record a cleaner removed, with the reason code.
+type Dropped struct {
+    Record
+    Reason string `json:"reason"`
+}
+
+// Columns are the resolved header row and columns cleaners work with.
+type Columns struct {
+    HeaderRow int      `json:"header_row"`
+    Headers   []string `json:"headers"`
+    Sales     string   `json:"sales_column"`
+    Bill      string   `json:"bill_column"`
+    Date      string   `json:"date_column,omitempty"`
+    Status    string   `json:"status_column,omitempty"` // marks returns and cancellations
+    // Format is how the sales column writes amounts, inferred from all of it.
+    Format utils.NumberFormat `json:"number_format"`
+}
+
+// Cleaner removes rows that must not count towards the metrics.
+type Cleaner interface {
+    Clean(recs []Record, cols Columns) (kept []Record, dropped []Dropped)
+}
+
+// CleanerFunc adapts a function to Cleaner.
+type CleanerFunc func(recs []Record, cols Columns) ([]Record, []Dropped)
+
+func (f CleanerFunc) Clean(recs []Record, cols Columns) ([]Record, []Dropped) {
+    return f(recs, cols)
+}
+
+// Clean runs cleaners in order and collects everything they dropped.
+func Clean(recs []Record, cols Columns, cleaners ...Cleaner) ([]Record, []Dropped) {
+    var dropped []Dropped
+    for _, c := range cleaners {
+        var d []Dropped
+        recs, d = c.Clean(recs, cols)
+        dropped = append(dropped, d...)
+    }
+    return recs, dropped
+}
+
+// Records turns the rows below the header into records.
+func Records(rows [][]string, headerIdx int, headers []string) []Record {
+    if headerIdx+1 >= len(rows) {
+        return []Record{}
+    }
+    out := make([]Record, 0, len(rows)-headerIdx-1)
+    for i := headerIdx + 1; i < len(rows); i++ {
+        r := rows[i]
+        m := make(map[string]string, len(headers))
+        for j := 0; j < len(headers); j++ {
+            var v string
+            if j < len(r) {
+                v = strings.TrimSpace(r[j])
+            }
+            m[headers[j]] = v
+        }
+        out = append(out, Record{Line: i + 1, Values: m})
+    }
+    return out
+}
+
+// filter keeps the records for which drop returns false.
+func filter(recs []Record, reason string, drop func(Record) bool) ([]Record, []Dropped) {
+    kept := make([]Record, 0, len(recs))
+    var dropped []Dropped
+    for _, r := range recs {
+        if drop(r) {
+            dropped = append(dropped, Dropped{Record: r, Reason: reason})
+            continue
+        }
+        kept = append(kept, r)
+    }
+    return kept, dropped
+}
+
+// DropBlankRows drops records with no value at all.
+var DropBlankRows Cleaner = CleanerFunc(func(recs []Record, _ Columns) ([]Record, []Dropped) {
+    return filter(recs, ReasonBlank, func(r Record) bool {
+        for _, v := range r.Values {
+            if strings.TrimSpace(v) != "" {
+                return false
+            }
+        }
+        return true
+    })
+})
+
+// dropExcluded drops records matching one of the configured exclusions.
+func (s ruleSet) dropExcluded(recs []Record, cols Columns) ([]Record, []Dropped) {
+    type match struct {
+        col    string
+        values map[string]struct{}
+    }
+    var ms []match
+    for _, x := range s.Exclusions {
+        m := match{values: map[string]struct{}{}}
+        for _, h := range cols.Headers {
+            if strings.EqualFold(h, strings.TrimSpace(x.Column)) {
+                m.col = h
+                break
+            }
+        }
+        if m.col == "" {
+            continue
+        }
+        for _, v := range x.Values {
+            m.values[strings.ToLower(strings.TrimSpace(v))] = struct{}{}
+        }
+        ms = append(ms, m)
+    }
+    return filter(recs, ReasonExcluded, func(r Record) bool {
+        for _, m := range ms {
+            if _, ok := m.values[strings.ToLower(strings.TrimSpace(r.Values[m.col]))]; ok {
+                return true
+            }
+        }
+        return false
+    })
+}
+
+// dropTotalishSecondColumn drops records whose second column (by header
+// order) reads like "Total", where POS exports label their subtotal lines.
+func (s ruleSet) dropTotalishSecondColumn(recs []Record, cols Columns) ([]Record, []Dropped) {
+    if len(cols.Headers) < 2 {
+        return recs, nil
+    }
+    second := cols.Headers[1]
+    if _, skip := s.ignore[strings.ToLower(second)]; skip {
+        return recs, nil
+    }
+    return filter(recs, ReasonTotalishSecondColumn, func(r Record) bool {
+        return s.totalish(r.Values[second])
+    })
+}
+
+// dropSummaryRows drops records with a total-ish word in any cell not
+// ignored, and records with an amount but no bill and almost nothing else.
+func (s ruleSet) dropSummaryRows(recs []Record, cols Columns) ([]Record, []Dropped) {
+    return filter(recs, ReasonSummaryRow, func(r Record) bool {
+        // rule 1: any 'total-ish' word anywhere
+        for k, v := range r.Values {
+            if _, skip := s.ignore[strings.ToLower(k)]; !skip && s.totalish(v) {
+                return true
+            }
+        }
+        // rule 2: bill missing, sales numeric present, almost nothing else
+        if !s.isEmptyBill(r.Values[cols.Bill]) || math.IsNaN(Amount(r.Values[cols.Sales], cols.Format)) {
+            return false
+        }
+        nonSalesNonEmpty := 0
+        for k, v := range r.Values {
+            if k == cols.Sales {
+                continue
+            }
+            if strings.TrimSpace(v) != "" && strings.ToLower(strings.TrimSpace(v)) != "nan" {
+                nonSalesNonEmpty++
+            }
+        }
+        return nonSalesNonEmpty <= 1
+    })
+}
+
+// dropEmptyBill keeps exactly the rows with a bill present; metrics are
+// computed from those.
+func (s ruleSet) dropEmptyBill(recs []Record, cols Columns) ([]Record, []Dropped) {
+    return filter(recs, ReasonEmptyBill, func(r Record) bool {
+        return s.isEmptyBill(r.Values[cols.Bill])
+    })
+}
+
+// DropNonNumericAmount drops records whose amount is filled in but cannot be
+// read as a number ("n/a", "see note"). Blank amounts are kept.
+var DropNonNumericAmount Cleaner = CleanerFunc(func(recs []Record, cols Columns) ([]Record, []Dropped) {
+    return filter(recs, ReasonNonNumericAmount, func(r Record) bool {
+        v := strings.TrimSpace(r.Values[cols.Sales])
+        return v != "" && !strings.EqualFold(v, "nan") && math.IsNaN(Amount(v, cols.Format))
+    })
+})
+
+var totalRegex = regexp.MustCompile(`(?i)\b(grand\s*)?sub\s*total\b|\bgrand\s*total\b|\btotal\b`)
+
+func editDistance(a, b string) int {
+    // simple Levenshtein distance
+    la, lb := len(a), len(b)
+    if la == 0 {
+        return lb
+    }
+    if lb == 0 {
+        return la
+    }
+    dp := make([][]int, la+1)
+    for i := range dp {
+        dp[i] = make([]int, lb+1)
+    }
+    for i := 0; i <= la; i++ {
+        dp[i][0] = i
+    }
+    for j := 0; j <= lb; j++ {
+        dp[0][j] = j
+    }
+    for i := 1; i <= la; i++ {
+        for j := 1; j <= lb; j++ {
+            cost := 0
+            if a[i-1] != b[j-1] {
+                cost = 1
+            }
+            dp[i][j] = min3(
+                dp[i-1][j]+1,
+                dp[i][j-1]+1,
+                dp[i-1][j-1]+cost,
+            )
+        }
+    }
+    return dp[la][lb]
+}
+
+func min3(a, b, c int) int {
+    m := a
+    if b < m { m = b }
+    if c < m { m = c }
+    return m
+}
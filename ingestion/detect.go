@@ -0,0 +1,269 @@
+package ingestion
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "strings"
+
+    "scalingwolf-ai/backend/utils"
+)
+
+// Detection is the header row and the column names picked for a sales table.
+type Detection struct {
+    HeaderRow int
+    Sales     string
+    Bill      string
+    Date      string // optional; empty when no date/time column was found
+    // Source says which detector answered: "confirmed", "cache", "ai" or
+    // "heuristic". Note carries its message, or why earlier detectors in a
+    // Chain gave up.
+    Source string
+    Note   string
+    // Rules, when set, replace the pipeline's cleaning rules; a saved
+    // mapping carries its own.
+    Rules *Rules
+}
+
+// NoDetection is returned by detectors that found nothing.
+var NoDetection = Detection{HeaderRow: -1}
+
+// Detector finds the header row and the sales, bill and date columns.
+// Column names may be approximate; the pipeline matches them to the headers
+// with FindColumn.
+type Detector interface {
+    Detect(ctx context.Context, rows [][]string) (Detection, error)
+}
+
+// DetectorFunc adapts a function to Detector.
+type DetectorFunc func(ctx context.Context, rows [][]string) (Detection, error)
+
+func (f DetectorFunc) Detect(ctx context.Context, rows [][]string) (Detection, error) {
+    return f(ctx, rows)
+}
+
+// Fixed always answers d, e.g. a mapping the user confirmed.
+func Fixed(d Detection) Detector {
+    return DetectorFunc(func(context.Context, [][]string) (Detection, error) {
+        return d, nil
+    })
+}
+
+// Heuristic detects columns by header keywords; see DetectHeuristic.
+var Heuristic Detector = DetectorFunc(func(_ context.Context, rows [][]string) (Detection, error) {
+    return DetectHeuristic(rows), nil
+})
+
+// Chain tries detectors in order and returns the first detection whose
+// sales and bill columns are found in the headers.
+func Chain(ds ...Detector) Detector {
+    return DetectorFunc(func(ctx context.Context, rows [][]string) (Detection, error) {
+        var notes []string
+        for _, d := range ds {
+            det, err := d.Detect(ctx, rows)
+            if err != nil {
+                notes = append(notes, err.Error())
+                continue
+            }
+            if !Resolves(rows, det) {
+                notes = append(notes, "no "+det.Source+" match")
+                continue
+            }
+            if det.Note == "" && len(notes) > 0 {
+                det.Note = strings.Join(notes, "; ")
+            }
+            return det, nil
+        }
+        if len(notes) == 0 {
+            return NoDetection, errors.New("no detectors")
+        }
+        return NoDetection, errors.New(strings.Join(notes, "; "))
+    })
+}
+
+// Resolves reports whether d names a header row whose headers contain its
+// sales and bill columns.
+func Resolves(rows [][]string, d Detection) bool {
+    if d.HeaderRow < 0 || d.HeaderRow >= len(rows) || strings.TrimSpace(d.Sales) == "" || strings.TrimSpace(d.Bill) == "" {
+        return false
+    }
+    headers := NormalizeHeaders(rows, d.HeaderRow)
+    return FindColumn(headers, d.Sales) != "" && FindColumn(headers, d.Bill) != ""
+}
+
+var (
+    salesKeywords = []string{"sales", "amount", "amt", "net amt", "net amount", "total", "grand total", "invoice amount", "subtotal", "item net amt"}
+    billKeywords  = []string{"bill", "bill no", "bill number", "invoice", "invoice no", "invoice number", "inv", "ref no", "reference", "voucher", "receipt"}
+    dateKeywords  = []string{"date", "bill date", "invoice date", "txn date", "transaction date", "order date", "voucher date", "created at", "timestamp", "date time", "datetime", "time"}
+)
+
+// DetectHeuristic takes the most text-like of the first five rows as the
+// header and picks columns by keyword.
+func DetectHeuristic(rows [][]string) Detection {
+    headerIdx := -1
+    bestScore := -1.0
+    for i, r := range rows {
+        nonEmpty := 0
+        alpha := 0
+        for _, v := range r {
+            t := strings.TrimSpace(v)
+            if t == "" {
+                continue
+            }
+            nonEmpty++
+            if hasLetter(t) {
+                alpha++
+            }
+        }
+        if nonEmpty == 0 {
+            continue
+        }
+        score := float64(alpha) / float64(nonEmpty)
+        if score >= 0.5 && score > bestScore {
+            bestScore = score
+            headerIdx = i
+        }
+        if i >= 4 { // look at first ~5 rows
+            break
+        }
+    }
+    if headerIdx == -1 {
+        headerIdx = 0
+    }
+    headers := NormalizeHeaders(rows, headerIdx)
+    sales := pickColumn(headers, salesKeywords)
+    bill := pickColumn(headers, billKeywords)
+    date := pickDateColumn(rows, headerIdx, headers, sales, bill)
+    return Detection{HeaderRow: headerIdx, Sales: sales, Bill: bill, Date: date, Source: "heuristic"}
+}
+
+// pickDateColumn returns the first date-named column whose values mostly parse
+// as dates. Name matches alone are not trusted ("Due Time" may hold "2h").
+func pickDateColumn(rows [][]string, headerIdx int, headers []string, exclude ...string) string {
+    var candidates []string
+    for _, k := range dateKeywords {
+        for _, h := range headers {
+            if strings.Contains(strings.ToLower(h), k) && !containsFold(exclude, h) && !containsFold(candidates, h) {
+                candidates = append(candidates, h)
+            }
+        }
+    }
+    for _, h := range candidates {
+        if looksLikeDateColumn(rows, headerIdx, headers, h) {
+            return h
+        }
+    }
+    return ""
+}
+
+// looksLikeDateColumn samples up to 20 non-empty values below the header.
+func looksLikeDateColumn(rows [][]string, headerIdx int, headers []string, col string) bool {
+    idx := -1
+    for i, h := range headers {
+        if h == col { idx = i; break }
+    }
+    if idx < 0 {
+        return false
+    }
+    samples := []string{}
+    for i := headerIdx + 1; i < len(rows) && len(samples) < 20; i++ {
+        if idx < len(rows[i]) && strings.TrimSpace(rows[i][idx]) != "" {
+            samples = append(samples, rows[i][idx])
+        }
+    }
+    if len(samples) == 0 {
+        return false
+    }
+    dayFirst := utils.DayFirst(samples)
+    ok := 0
+    for _, v := range samples {
+        if _, parsed := utils.ParseDate(v, dayFirst); parsed {
+            ok++
+        }
+    }
+    return ok*2 >= len(samples)
+}
+
+// ResolveDateColumn validates the detected date column against the data and
+// falls back to the keyword heuristic when it is missing or unparseable.
+func ResolveDateColumn(rows [][]string, headerIdx int, headers []string, detected, salesCol, billCol string) string {
+    if detected != "" {
+        if col := FindColumn(headers, detected); col != "" && col != salesCol && col != billCol && looksLikeDateColumn(rows, headerIdx, headers, col) {
+            return col
+        }
+    }
+    return pickDateColumn(rows, headerIdx, headers, salesCol, billCol)
+}
+
+func containsFold(list []string, s string) bool {
+    for _, v := range list {
+        if strings.EqualFold(v, s) {
+            return true
+        }
+    }
+    return false
+}
+
+func hasLetter(s string) bool {
+    for _, ch := range s {
+        if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') {
+            return true
+        }
+    }
+    return false
+}
+
+// NormalizeHeaders trims the header row's names; blank cells become ColN.
+func NormalizeHeaders(rows [][]string, headerIdx int) []string {
+    if headerIdx < 0 || headerIdx >= len(rows) {
+        return nil
+    }
+    raw := rows[headerIdx]
+    headers := make([]string, len(raw))
+    for i, v := range raw {
+        t := strings.TrimSpace(v)
+        if t == "" {
+            t = "Col" + strconv.Itoa(i)
+        }
+        headers[i] = t
+    }
+    return headers
+}
+
+func pickColumn(headers []string, keywords []string) string {
+    // exact match preferred
+    for _, k := range keywords {
+        for _, h := range headers {
+            if strings.EqualFold(h, k) {
+                return h
+            }
+        }
+    }
+    // substring fallback
+    for _, k := range keywords {
+        lk := strings.ToLower(k)
+        for _, h := range headers {
+            if strings.Contains(strings.ToLower(h), lk) {
+                return h
+            }
+        }
+    }
+    return ""
+}
+
+// FindColumn maps a detected name to the actual header (case-insensitive,
+// then substring).
+func FindColumn(headers []string, target string) string {
+    t := strings.TrimSpace(strings.ToLower(target))
+    for _, h := range headers {
+        if strings.ToLower(strings.TrimSpace(h)) == t {
+            return h
+        }
+    }
+    for _, h := range headers {
+        if t != "" && strings.Contains(strings.ToLower(h), t) {
+            return h
+        }
+    }
+    return ""
+}
@@ -0,0 +1,207 @@
+// Package ingestion turns the rows of an uploaded sales sheet into metrics:
+// detect the header row and columns, clean out blank and summary rows, then
+// total the amounts. It has no HTTP or database dependencies; callers read
+// the file and persist the Result.
+package ingestion
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+
+    "scalingwolf-ai/backend/utils"
+)
+
+// ErrNoColumns is returned by Run when the sales or bill column cannot be
+// found in the detected header row.
+var ErrNoColumns = errors.New("sales and bill columns not found")
+
+// ErrAmountOverflow is returned by Run when amounts add up beyond the range
+// of a float64, so no total or bill value can be reported.
+var ErrAmountOverflow = errors.New("amounts too large to total")
+
+// Pipeline is a detector followed by cleaning steps. Rules configure the
+// steps unless the detection brings its own (a saved mapping's rules).
+type Pipeline struct {
+    Detector Detector
+    Rules    Rules
+}
+
+// New returns a pipeline using d and the default rules.
+func New(d Detector) *Pipeline {
+    return &Pipeline{Detector: d, Rules: DefaultRules()}
+}
+
+// Metrics are the figures stored for a sales upload.
+type Metrics struct {
+    TotalSales      float64 `json:"total_sales"`
+    BillRowCount    int     `json:"bill_row_count"`
+    UniqueBillCount int     `json:"unique_bill_count"`
+}
+
+// Report counts what cleaning removed and how many amounts could not be read.
+type Report struct {
+    DroppedBlankRows         int `json:"dropped_blank_rows"`
+    DroppedTotalishSecondCol int `json:"dropped_totalish_second_col"`
+    DroppedSummaryRows       int `json:"dropped_summary_rows"`
+    DroppedEmptyBillRows     int `json:"dropped_empty_bill_rows"`
+    DroppedExcludedRows      int `json:"dropped_excluded_rows"`
+    // AmountParseFailures are the rows dropped for a non-numeric amount.
+    AmountParseFailures int `json:"amount_parse_failures"`
+    FinalRowsUsed       int `json:"final_rows_used"`
+}
+
+// Transaction is one cleaned row kept from a file upload.
+type Transaction struct {
+    LineNo  int               `json:"line_no"` // 1-based row number in the sheet, as in Record.Line
+    BillID  string            `json:"bill_id"`
+    Kind    string            `json:"kind"` // KindSale, KindReturn or KindCancelled
+    Amount  *float64          `json:"amount"`
+    TxnDate *string           `json:"txn_date"` // YYYY-MM-DD when a date column was detected
+    Raw     map[string]string `json:"raw"`
+}
+
+// Result is everything a run produced.
+type Result struct {
+    Detection    Detection
+    Columns      Columns
+    Rules        Rules // the cleaning rules applied
+    NumberFormat utils.NumberFormat
+    Used         []Record
+    Dropped      []Dropped
+    Transactions []Transaction
+    Metrics      Metrics
+    Revenue      Revenue
+    Bills        Bills
+    Report       Report
+}
+
+// Run detects columns in rows, cleans the records and computes the metrics.
+func (p *Pipeline) Run(ctx context.Context, rows [][]string) (*Result, error) {
+    if len(rows) == 0 {
+        return nil, errors.New("no rows found in file")
+    }
+    det, err := p.Detector.Detect(ctx, rows)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrNoColumns, err)
+    }
+    if det.HeaderRow < 0 || det.HeaderRow >= len(rows) {
+        return nil, errors.New("could not detect header row")
+    }
+    headers := NormalizeHeaders(rows, det.HeaderRow)
+    if len(headers) == 0 {
+        return nil, errors.New("empty header row")
+    }
+    cols := Columns{HeaderRow: det.HeaderRow, Headers: headers, Sales: FindColumn(headers, det.Sales), Bill: FindColumn(headers, det.Bill)}
+    if cols.Sales == "" || cols.Bill == "" {
+        return nil, fmt.Errorf("%w: detected sales %q, bill %q; headers %q", ErrNoColumns, det.Sales, det.Bill, headers)
+    }
+    cols.Date = ResolveDateColumn(rows, det.HeaderRow, headers, det.Date, cols.Sales, cols.Bill)
+    cols.Status = pickStatusColumn(headers, cols.Sales, cols.Bill, cols.Date)
+
+    // Amounts are read in the sales column's own format
+    recs := Records(rows, det.HeaderRow, headers)
+    cols.Format = ColumnNumberFormat(recs, cols.Sales)
+
+    rules := p.Rules
+    if det.Rules != nil {
+        rules = *det.Rules
+    }
+    res := &Result{Detection: det, Columns: cols, Rules: rules, NumberFormat: cols.Format}
+    res.Used, res.Dropped = Clean(recs, cols, rules.Cleaners()...)
+    for _, d := range res.Dropped {
+        switch d.Reason {
+        case ReasonBlank:
+            res.Report.DroppedBlankRows++
+        case ReasonTotalishSecondColumn:
+            res.Report.DroppedTotalishSecondCol++
+        case ReasonSummaryRow:
+            res.Report.DroppedSummaryRows++
+        case ReasonEmptyBill:
+            res.Report.DroppedEmptyBillRows++
+        case ReasonNonNumericAmount:
+            res.Report.AmountParseFailures++
+        case ReasonExcluded:
+            res.Report.DroppedExcludedRows++
+        }
+    }
+
+    res.Transactions = buildTransactions(res.Used, cols)
+    bills := map[string]struct{}{}
+    for _, t := range res.Transactions {
+        if t.Amount != nil {
+            res.Metrics.TotalSales += *t.Amount
+        }
+        if t.BillID != "" {
+            bills[BillKey(t.BillID)] = struct{}{}
+        }
+    }
+    res.Metrics.BillRowCount = len(res.Used)
+    res.Metrics.UniqueBillCount = len(bills)
+    res.Revenue = revenue(res.Transactions)
+    rv := res.Revenue
+    if !finite(res.Metrics.TotalSales, rv.GrossSales, rv.Returns, rv.CancelledSales) {
+        return nil, ErrAmountOverflow
+    }
+    if res.Bills, err = aggregateBills(res.Transactions); err != nil {
+        return nil, err
+    }
+    res.Report.FinalRowsUsed = len(res.Used)
+    return res, nil
+}
+
+// Amount reads s in format f, NaN when it is not an amount.
+func Amount(s string, f utils.NumberFormat) float64 {
+    v, ok := utils.ParseAmount(s, f)
+    if !ok {
+        return math.NaN()
+    }
+    return v
+}
+
+// ColumnNumberFormat infers how col writes its amounts from up to 500 of
+// its non-blank values.
+func ColumnNumberFormat(recs []Record, col string) utils.NumberFormat {
+    samples := make([]string, 0, 500)
+    for _, r := range recs {
+        if len(samples) == cap(samples) {
+            break
+        }
+        if v := strings.TrimSpace(r.Values[col]); v != "" {
+            samples = append(samples, v)
+        }
+    }
+    return utils.DetectNumberFormat(samples)
+}
+
+// buildTransactions turns the records used for metrics into storable
+// transactions, one per record.
+func buildTransactions(used []Record, cols Columns) []Transaction {
+    dayFirst := true
+    if cols.Date != "" {
+        samples := make([]string, 0, 50)
+        for _, r := range used {
+            if len(samples) == cap(samples) { break }
+            if v := strings.TrimSpace(r.Values[cols.Date]); v != "" { samples = append(samples, v) }
+        }
+        dayFirst = utils.DayFirst(samples)
+    }
+    out := make([]Transaction, 0, len(used))
+    for _, r := range used {
+        t := Transaction{LineNo: r.Line, BillID: strings.TrimSpace(r.Values[cols.Bill]), Raw: r.Values}
+        if v := Amount(r.Values[cols.Sales], cols.Format); !math.IsNaN(v) {
+            t.Amount = &v
+        }
+        t.Kind = classify(t.BillID, r.Values[cols.Status], t.Amount)
+        if cols.Date != "" {
+            if d, ok := utils.ParseDate(r.Values[cols.Date], dayFirst); ok {
+                ds := d.Format("2006-01-02")
+                t.TxnDate = &ds
+            }
+        }
+        out = append(out, t)
+    }
+    return out
+}
@@ -0,0 +1,237 @@
+package ingestion
+
+import (
+    "context"
+    "errors"
+    "math"
+    "testing"
+)
+
+// posExport is a typical POS export: a title line, the header, sales lines,
+// a blank line, a subtotal, a line without a bill and a grand total.
+var posExport = [][]string{
+    {"Daily Sales Report", "01/02/2024 - 13/02/2024", "", ""},
+    {"Bill No", "Item", "Net Amt", "Bill Date"},
+    {"B1", "Coffee", "120.50", "01/02/2024"},
+    {"B1", "Cake", "80", "01/02/2024"},
+    {"B2", "Tea", "(20)", "02/02/2024"},
+    {"", "", "", ""},
+    {"", "Sub Total", "180.50", ""},
+    {"-", "Tips", "15", "02/02/2024"},
+    {"B3", "Coffee", "1,000.00", "13/02/2024"},
+    {"Grand Total", "", "1180.50", ""},
+}
+
+func TestPipelineHeuristic(t *testing.T) {
+    res, err := New(Heuristic).Run(context.Background(), posExport)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    cols := res.Columns
+    if cols.HeaderRow != 1 || cols.Sales != "Net Amt" || cols.Bill != "Bill No" || cols.Date != "Bill Date" {
+        t.Fatalf("columns = %+v", cols)
+    }
+    want := Metrics{TotalSales: 1180.5, BillRowCount: 4, UniqueBillCount: 3}
+    if res.Metrics != want {
+        t.Fatalf("metrics = %+v, want %+v", res.Metrics, want)
+    }
+    wantReport := Report{DroppedBlankRows: 1, DroppedTotalishSecondCol: 1, DroppedSummaryRows: 1, DroppedEmptyBillRows: 1, FinalRowsUsed: 4}
+    if res.Report != wantReport {
+        t.Fatalf("report = %+v, want %+v", res.Report, wantReport)
+    }
+
+    // Every dropped row keeps its sheet line and reason
+    reasons := map[int]string{}
+    for _, d := range res.Dropped {
+        reasons[d.Line] = d.Reason
+    }
+    wantReasons := map[int]string{6: ReasonBlank, 7: ReasonTotalishSecondColumn, 8: ReasonEmptyBill, 10: ReasonSummaryRow}
+    for line, reason := range wantReasons {
+        if reasons[line] != reason {
+            t.Errorf("line %d dropped as %q, want %q", line, reasons[line], reason)
+        }
+    }
+    if len(res.Dropped) != len(wantReasons) {
+        t.Errorf("dropped %d rows, want %d", len(res.Dropped), len(wantReasons))
+    }
+
+    // B2's negative line is a return; B1's two lines make one bill
+    bills := res.Bills
+    if bills.Count != 2 || bills.AverageValue != 600.25 || bills.MedianValue != 600.25 || bills.AverageItems != 1.5 {
+        t.Errorf("bills = %+v", bills)
+    }
+    if len(bills.Top) != 2 || bills.Top[0].BillID != "B3" || bills.Top[1].Total != 200.5 || bills.Top[1].Items != 2 {
+        t.Errorf("top bills = %+v", bills.Top)
+    }
+    wantBuckets := []BillBucket{{0, 200, 0}, {200, 500, 1}, {500, 1000, 0}, {1000, 2000, 1}}
+    if len(bills.Distribution) != len(wantBuckets) {
+        t.Fatalf("distribution = %+v, want %+v", bills.Distribution, wantBuckets)
+    }
+    for i, b := range wantBuckets {
+        if bills.Distribution[i] != b {
+            t.Errorf("bucket %d = %+v, want %+v", i, bills.Distribution[i], b)
+        }
+    }
+
+    last := res.Transactions[len(res.Transactions)-1]
+    if last.LineNo != 9 {
+        t.Errorf("last txn line = %d, want its sheet row 9", last.LineNo)
+    }
+    if last.TxnDate == nil || *last.TxnDate != "2024-02-13" {
+        t.Errorf("last txn date = %v, want 2024-02-13", last.TxnDate)
+    }
+}
+
+func TestPipelineAmountFormats(t *testing.T) {
+    rows := [][]string{
+        {"Invoice", "Betrag"},
+        {"R1", "1.234,50"},
+        {"R2", "12,5"},
+        {"R3", "n/v"},
+    }
+    detector := Fixed(Detection{HeaderRow: 0, Sales: "betrag", Bill: "invoice"})
+    res, err := New(detector).Run(context.Background(), rows)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if !res.NumberFormat.DecimalComma {
+        t.Fatal("expected a decimal-comma column")
+    }
+    if res.Metrics.TotalSales != 1247 || res.Metrics.BillRowCount != 2 || res.Report.AmountParseFailures != 1 {
+        t.Fatalf("metrics = %+v, parse failures = %d", res.Metrics, res.Report.AmountParseFailures)
+    }
+    if len(res.Dropped) != 1 || res.Dropped[0].Line != 4 || res.Dropped[0].Reason != ReasonNonNumericAmount {
+        t.Fatalf("dropped = %+v, want line 4 as %s", res.Dropped, ReasonNonNumericAmount)
+    }
+}
+
+func TestPipelineRules(t *testing.T) {
+    rows := [][]string{
+        {"Bill No", "Item", "Amount", "Status"},
+        {"B1", "tota1", "100", "paid"},
+        {"0", "Tea", "50", "paid"},
+        {"B2", "Total Gym", "300", "paid"},
+        {"B3", "Coffee", "20", "VOID"},
+        {"B4", "Coffee", "470", "Net Payable"},
+    }
+    rules := DefaultRules()
+    rules.FuzzyTotal = false
+    rules.EmptyBillTokens = []string{"-", "n/a"}
+    rules.SummaryKeywords = []string{"net payable"}
+    rules.SummaryIgnoreColumns = []string{"item"}
+    rules.Exclusions = []Exclusion{{Column: "status", Values: []string{"void"}}}
+    if err := rules.Validate(); err != nil {
+        t.Fatalf("Validate: %v", err)
+    }
+    detector := Fixed(Detection{HeaderRow: 0, Sales: "Amount", Bill: "Bill No", Rules: &rules})
+    res, err := New(detector).Run(context.Background(), rows)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if res.Metrics.TotalSales != 450 || res.Metrics.BillRowCount != 3 {
+        t.Fatalf("metrics = %+v, want 450 over 3 rows", res.Metrics)
+    }
+    want := Report{DroppedSummaryRows: 1, DroppedExcludedRows: 1, FinalRowsUsed: 3}
+    if res.Report != want {
+        t.Fatalf("report = %+v, want %+v", res.Report, want)
+    }
+    if res.Rules.FuzzyTotal {
+        t.Error("result should carry the rules applied")
+    }
+
+    bad := DefaultRules()
+    bad.Enabled = []string{"blank", "typo"}
+    if bad.Validate() == nil {
+        t.Error("Validate accepted an unknown rule")
+    }
+}
+
+func TestPipelineReturns(t *testing.T) {
+    rows := [][]string{
+        {"Invoice No", "Amount", "Status"},
+        {"INV-1", "500", "Completed"},
+        {"INV-2", "300", "Completed"},
+        {"CN-0001", "120", "Completed"},
+        {"INV-3", "-80", ""},
+        {"INV-4", "200", "Cancelled"},
+        {"INV-5", "50", "Refunded"},
+        {"CNTR-9", "70", ""},
+    }
+    res, err := New(Heuristic).Run(context.Background(), rows)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if res.Columns.Status != "Status" {
+        t.Fatalf("status column = %q", res.Columns.Status)
+    }
+    want := Revenue{GrossSales: 870, Returns: 250, NetSales: 620, CancelledSales: 200, SaleRows: 3, ReturnRows: 3, CancelledRows: 1, UniqueSaleBills: 3}
+    if res.Revenue != want {
+        t.Fatalf("revenue = %+v, want %+v", res.Revenue, want)
+    }
+    if res.Metrics.TotalSales != 1160 {
+        t.Errorf("total sales = %v, want the plain sum 1160", res.Metrics.TotalSales)
+    }
+}
+
+func TestPipelineBillCase(t *testing.T) {
+    rows := [][]string{
+        {"Bill No", "Amount"},
+        {"inv-7", "100"},
+        {"INV-7", "50"},
+        {"Inv-8", "30"},
+    }
+    res, err := New(Heuristic).Run(context.Background(), rows)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    // Every count folds case the same way, whichever BEP bill basis reads it
+    if res.Metrics.UniqueBillCount != 2 || res.Revenue.UniqueSaleBills != 2 || res.Bills.Count != 2 {
+        t.Errorf("unique bills = %d metrics, %d revenue, %d bills; want 2", res.Metrics.UniqueBillCount, res.Revenue.UniqueSaleBills, res.Bills.Count)
+    }
+}
+
+func TestPipelineAmountOverflow(t *testing.T) {
+    // Each line parses, but the bill's total is +Inf
+    rows := [][]string{
+        {"Bill No", "Amount"},
+        {"B1", "1.7e308"},
+        {"B1", "1.7e308"},
+    }
+    if _, err := New(Heuristic).Run(context.Background(), rows); !errors.Is(err, ErrAmountOverflow) {
+        t.Fatalf("Run err = %v, want ErrAmountOverflow", err)
+    }
+}
+
+func TestBillBucketsEnds(t *testing.T) {
+    // Steps from a subnormal value never grow; the loop must still stop
+    cases := [][]float64{{5e-324, 100}, {5e-324}, {1, math.MaxFloat64}}
+    for _, values := range cases {
+        out := billBuckets(values)
+        total := 0
+        for _, b := range out {
+            total += b.Bills
+        }
+        if total != len(values) {
+            t.Errorf("billBuckets(%v) = %+v; want all %d values bucketed", values, out, len(values))
+        }
+    }
+}
+
+func TestChainFallsBack(t *testing.T) {
+    failing := DetectorFunc(func(context.Context, [][]string) (Detection, error) {
+        return NoDetection, errors.New("AI provider not configured")
+    })
+    wrong := Fixed(Detection{HeaderRow: 1, Sales: "Revenue", Bill: "Bill No", Source: "cache"})
+    det, err := Chain(failing, wrong, Heuristic).Detect(context.Background(), posExport)
+    if err != nil {
+        t.Fatalf("Detect: %v", err)
+    }
+    if det.Source != "heuristic" || det.Note == "" {
+        t.Fatalf("detection = %+v, want heuristic with a note", det)
+    }
+
+    _, err = New(Chain(failing)).Run(context.Background(), posExport)
+    if !errors.Is(err, ErrNoColumns) {
+        t.Fatalf("Run with no usable detector: err = %v, want ErrNoColumns", err)
+    }
+}
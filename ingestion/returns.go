@@ -0,0 +1,92 @@
+package ingestion
+
+import (
+    "math"
+    "regexp"
+)
+
+// What a kept row is, by its amount, status and bill number.
+const (
+    KindSale      = "sale"
+    KindReturn    = "return"    // credit note, refund or negative amount
+    KindCancelled = "cancelled" // void or cancelled bill; counts towards neither sales nor returns
+)
+
+// Revenue splits the amounts of the kept rows by kind. Metrics.TotalSales
+// stays the plain sum of the sales column.
+type Revenue struct {
+    GrossSales      float64 `json:"gross_sales"` // sale rows only
+    Returns         float64 `json:"returns"`     // returned amounts, as a positive figure
+    NetSales        float64 `json:"net_sales"`   // gross_sales - returns
+    CancelledSales  float64 `json:"cancelled_sales"`
+    SaleRows        int     `json:"sale_rows"`
+    ReturnRows      int     `json:"return_rows"`
+    CancelledRows   int     `json:"cancelled_rows"`
+    UniqueSaleBills int     `json:"unique_sale_bills"`
+}
+
+var statusKeywords = []string{"status", "bill status", "order status", "invoice status", "txn type", "transaction type", "bill type", "voucher type", "type"}
+
+var (
+    cancelledStatus = regexp.MustCompile(`(?i)\b(cancel(l?ed)?|void(ed)?|deleted)\b`)
+    returnStatus    = regexp.MustCompile(`(?i)\b(returns?|returned|refund(ed)?|credit\s*note|cn)\b`)
+    // "CN-0012", "CN/24/7", "Return 5", "RET12"; not "CNTR1"
+    returnBillPrefix = regexp.MustCompile(`(?i)^(cn|ret|return)([^a-z]|$)`)
+)
+
+// pickStatusColumn returns the first status- or type-named column other
+// than the sales, bill and date columns.
+func pickStatusColumn(headers []string, exclude ...string) string {
+    rest := make([]string, 0, len(headers))
+    for _, h := range headers {
+        if !containsFold(exclude, h) {
+            rest = append(rest, h)
+        }
+    }
+    return pickColumn(rest, statusKeywords)
+}
+
+// classify tells sales from returns and cancellations. A cancelled status
+// wins; otherwise a return status, a credit-note bill number or a negative
+// amount make a return.
+func classify(billID, status string, amount *float64) string {
+    if cancelledStatus.MatchString(status) {
+        return KindCancelled
+    }
+    if returnStatus.MatchString(status) || returnBillPrefix.MatchString(billID) {
+        return KindReturn
+    }
+    if amount != nil && *amount < 0 {
+        return KindReturn
+    }
+    return KindSale
+}
+
+// revenue totals txns by kind.
+func revenue(txns []Transaction) Revenue {
+    var r Revenue
+    bills := map[string]struct{}{}
+    for _, t := range txns {
+        amt := 0.0
+        if t.Amount != nil {
+            amt = *t.Amount
+        }
+        switch t.Kind {
+        case KindCancelled:
+            r.CancelledRows++
+            r.CancelledSales += math.Abs(amt)
+        case KindReturn:
+            r.ReturnRows++
+            r.Returns += math.Abs(amt)
+        default:
+            r.SaleRows++
+            r.GrossSales += amt
+            if t.BillID != "" {
+                bills[BillKey(t.BillID)] = struct{}{}
+            }
+        }
+    }
+    r.NetSales = r.GrossSales - r.Returns
+    r.UniqueSaleBills = len(bills)
+    return r
+}
@@ -0,0 +1,148 @@
+package ingestion
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Rules configure the cleaning steps. Start from DefaultRules and decode
+// saved rules over it, so fields a client leaves out keep their defaults.
+type Rules struct {
+    // Enabled are the cleaning steps to run, by reason code. They always run
+    // in the order of DefaultRules.
+    Enabled []string `json:"enabled"`
+    // FuzzyTotal also takes words one edit away from "total" ("totl") as
+    // total-ish. Turn it off when codes like "tota1" appear in the data.
+    FuzzyTotal bool `json:"fuzzy_total"`
+    // SummaryKeywords are words or phrases besides total, subtotal and grand
+    // total that mark a summary row, e.g. "net payable".
+    SummaryKeywords []string `json:"summary_keywords"`
+    // SummaryIgnoreColumns are not searched for total-ish words, e.g. an
+    // item column holding "Total Gym".
+    SummaryIgnoreColumns []string `json:"summary_ignore_columns"`
+    // EmptyBillTokens are the bill values that mean "no bill", compared
+    // case-insensitively. A blank bill is always empty.
+    EmptyBillTokens []string `json:"empty_bill_tokens"`
+    // Exclusions drop rows whose column holds one of the given values.
+    Exclusions []Exclusion `json:"exclusions"`
+}
+
+// Exclusion drops rows whose Column holds one of Values, compared
+// case-insensitively after trimming.
+type Exclusion struct {
+    Column string   `json:"column"`
+    Values []string `json:"values"`
+}
+
+// steps are the reason codes that can be enabled, in the order they run.
+// Exclusions run before them whenever there are any.
+var steps = []string{ReasonBlank, ReasonTotalishSecondColumn, ReasonSummaryRow, ReasonEmptyBill, ReasonNonNumericAmount}
+
+// DefaultRules are the rules applied when nothing else is configured.
+func DefaultRules() Rules {
+    return Rules{
+        Enabled:         append([]string(nil), steps...),
+        FuzzyTotal:      true,
+        EmptyBillTokens: []string{"-", "na", "n/a", "none", "null", "nil", "nan", "0"},
+    }
+}
+
+// Validate checks reason codes and exclusions. Column names are checked
+// against the headers by callers that know them.
+func (r Rules) Validate() error {
+    for _, e := range r.Enabled {
+        if !containsFold(steps, e) {
+            return fmt.Errorf("unknown cleaning rule %q; use one of %s", e, strings.Join(steps, ", "))
+        }
+    }
+    for _, k := range r.SummaryKeywords {
+        if strings.TrimSpace(k) == "" {
+            return errors.New("summary_keywords must not be blank")
+        }
+    }
+    for _, x := range r.Exclusions {
+        if strings.TrimSpace(x.Column) == "" || len(x.Values) == 0 {
+            return errors.New("each exclusion needs a column and values")
+        }
+        for _, v := range x.Values {
+            if strings.TrimSpace(v) == "" {
+                return fmt.Errorf("exclusion values for %q must not be blank", x.Column)
+            }
+        }
+    }
+    return nil
+}
+
+// Cleaners returns the enabled cleaning steps in order.
+func (r Rules) Cleaners() []Cleaner {
+    s := compile(r)
+    out := []Cleaner{}
+    if len(r.Exclusions) > 0 {
+        out = append(out, CleanerFunc(s.dropExcluded))
+    }
+    for _, step := range steps {
+        if !containsFold(r.Enabled, step) {
+            continue
+        }
+        switch step {
+        case ReasonBlank:
+            out = append(out, DropBlankRows)
+        case ReasonTotalishSecondColumn:
+            out = append(out, CleanerFunc(s.dropTotalishSecondColumn))
+        case ReasonSummaryRow:
+            out = append(out, CleanerFunc(s.dropSummaryRows))
+        case ReasonEmptyBill:
+            out = append(out, CleanerFunc(s.dropEmptyBill))
+        case ReasonNonNumericAmount:
+            out = append(out, DropNonNumericAmount)
+        }
+    }
+    return out
+}
+
+// ruleSet is Rules prepared for matching.
+type ruleSet struct {
+    Rules
+    emptyBill map[string]struct{}
+    keywords  *regexp.Regexp
+    ignore    map[string]struct{}
+}
+
+func compile(r Rules) ruleSet {
+    s := ruleSet{Rules: r, emptyBill: map[string]struct{}{"": {}}, ignore: map[string]struct{}{}}
+    for _, t := range r.EmptyBillTokens {
+        s.emptyBill[strings.TrimSpace(strings.ToLower(t))] = struct{}{}
+    }
+    var alts []string
+    for _, k := range r.SummaryKeywords {
+        if k = strings.TrimSpace(k); k != "" {
+            alts = append(alts, strings.Join(strings.Fields(regexp.QuoteMeta(k)), `\s+`))
+        }
+    }
+    if len(alts) > 0 {
+        s.keywords = regexp.MustCompile(`(?i)(^|\W)(` + strings.Join(alts, "|") + `)($|\W)`)
+    }
+    for _, c := range r.SummaryIgnoreColumns {
+        s.ignore[strings.ToLower(strings.TrimSpace(c))] = struct{}{}
+    }
+    return s
+}
+
+func (s ruleSet) isEmptyBill(x string) bool {
+    _, ok := s.emptyBill[strings.TrimSpace(strings.ToLower(x))]
+    return ok
+}
+
+func (s ruleSet) totalish(v string) bool {
+    t := strings.TrimSpace(strings.ToLower(v))
+    if t == "" {
+        return false
+    }
+    if totalRegex.MatchString(t) || (s.keywords != nil && s.keywords.MatchString(t)) {
+        return true
+    }
+    // very small fuzzy: allow one edit away from "total"
+    return s.FuzzyTotal && editDistance(t, "total") <= 1
+}
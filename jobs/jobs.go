@@ -0,0 +1,216 @@
+package jobs
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "scalingwolf-ai/backend/database"
+)
+
+// Job statuses stored in jobs.status.
+const (
+    StatusQueued    = "queued"
+    StatusRunning   = "running"
+    StatusSucceeded = "succeeded"
+    StatusFailed    = "failed"
+)
+
+const (
+    // defaultMaxAttempts is how often a job runs before it is marked failed.
+    defaultMaxAttempts = 3
+    // runTimeout bounds one attempt; a running job whose lock is older than
+    // staleAfter is assumed to belong to a dead worker and is claimed again.
+    runTimeout  = 10 * time.Minute
+    staleAfter  = runTimeout + 2*time.Minute
+    baseBackoff = 15 * time.Second
+)
+
+// Job is a claimed job handed to its Handler.
+type Job struct {
+    ID       int64
+    UserID   int64
+    OrgID    int64
+    Kind     string
+    Params   json.RawMessage
+    Payload  []byte
+    Attempts int
+}
+
+// Progress records how far the job has got (0-100) and what it is doing.
+func (j *Job) Progress(ctx context.Context, pct int, note string) {
+    if pct < 0 { pct = 0 }
+    if pct > 100 { pct = 100 }
+    if _, err := database.Pool.Exec(ctx, `UPDATE jobs SET progress=$2, progress_note=$3, updated_at=now() WHERE id=$1`, j.ID, pct, note); err != nil {
+        log.Printf("job %d progress error: %v", j.ID, err)
+    }
+}
+
+// Handler runs one attempt of a job and returns its JSON-encodable result.
+type Handler func(ctx context.Context, job *Job) (any, error)
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying (bad input rather than a
+// transient failure), so the job fails on this attempt.
+func Permanent(err error) error {
+    if err == nil {
+        return nil
+    }
+    return permanentError{err}
+}
+
+var (
+    mu       sync.RWMutex
+    handlers = map[string]Handler{}
+)
+
+// Register installs the handler for a job kind. Call before Start.
+func Register(kind string, h Handler) {
+    mu.Lock()
+    defer mu.Unlock()
+    handlers[kind] = h
+}
+
+// Enqueue stores a job for the user's org and returns its id. params is
+// marshalled to JSON; payload holds raw input such as an uploaded file.
+func Enqueue(ctx context.Context, userID, orgID int64, kind string, params any, payload []byte) (int64, error) {
+    mu.RLock()
+    _, ok := handlers[kind]
+    mu.RUnlock()
+    if !ok {
+        return 0, fmt.Errorf("no handler for job kind %q", kind)
+    }
+    pb, err := json.Marshal(params)
+    if err != nil {
+        return 0, err
+    }
+    var id int64
+    err = database.Pool.QueryRow(ctx, `INSERT INTO jobs(user_id, org_id, kind, params, payload, max_attempts) VALUES($1,$2,$3,$4::jsonb,$5,$6) RETURNING id`,
+        userID, orgID, kind, string(pb), payload, defaultMaxAttempts).Scan(&id)
+    return id, err
+}
+
+// Start runs n workers that poll for due jobs until ctx is cancelled.
+func Start(ctx context.Context, n int, poll time.Duration) {
+    if n <= 0 {
+        return
+    }
+    for i := 0; i < n; i++ {
+        go work(ctx, poll)
+    }
+    log.Printf("job workers started: %d", n)
+}
+
+func work(ctx context.Context, poll time.Duration) {
+    for {
+        job, err := claim(ctx)
+        if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+            log.Printf("job claim error: %v", err)
+        }
+        if job != nil {
+            run(job)
+            continue // look for more work straight away
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(poll):
+        }
+    }
+}
+
+// claim takes the oldest due job. SKIP LOCKED lets workers in every process
+// poll the same table without handing one job to two of them.
+func claim(ctx context.Context) (*Job, error) {
+    var j Job
+    var params string
+    err := database.Pool.QueryRow(ctx, `
+        UPDATE jobs SET status='running', attempts=attempts+1, locked_at=now(), updated_at=now()
+        WHERE id = (
+            SELECT id FROM jobs
+            WHERE (status='queued' AND run_at <= now())
+               OR (status='running' AND locked_at < now() - make_interval(secs => $1::double precision))
+            ORDER BY run_at, id
+            FOR UPDATE SKIP LOCKED
+            LIMIT 1
+        )
+        RETURNING id, user_id, org_id, kind, params::text, COALESCE(payload, ''::bytea), attempts`, staleAfter.Seconds()).
+        Scan(&j.ID, &j.UserID, &j.OrgID, &j.Kind, &params, &j.Payload, &j.Attempts)
+    if err != nil {
+        return nil, err
+    }
+    j.Params = json.RawMessage(params)
+    return &j, nil
+}
+
+func run(job *Job) {
+    mu.RLock()
+    h, ok := handlers[job.Kind]
+    mu.RUnlock()
+    ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+    defer cancel()
+    var result any
+    var err error
+    if !ok {
+        err = Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+    } else {
+        result, err = safeRun(ctx, h, job)
+    }
+    // Finish on a fresh context so a timed-out attempt is still recorded.
+    fctx, fcancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer fcancel()
+    if err == nil {
+        var rb []byte
+        if rb, err = json.Marshal(result); err == nil {
+            _, uerr := database.Pool.Exec(fctx, `UPDATE jobs SET status='succeeded', progress=100, result=$2::jsonb, error=NULL, payload=NULL, locked_at=NULL, finished_at=now(), updated_at=now() WHERE id=$1`, job.ID, string(rb))
+            if uerr != nil {
+                log.Printf("job %d finish error: %v", job.ID, uerr)
+            }
+            return
+        }
+        err = Permanent(err)
+    }
+    retry := !errors.As(err, &permanentError{})
+    log.Printf("job %d (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, err)
+    _, uerr := database.Pool.Exec(fctx, `
+        UPDATE jobs SET
+            status = CASE WHEN $2::boolean AND attempts < max_attempts THEN 'queued' ELSE 'failed' END,
+            run_at = now() + make_interval(secs => $3::double precision),
+            finished_at = CASE WHEN $2::boolean AND attempts < max_attempts THEN NULL ELSE now() END,
+            payload = CASE WHEN $2::boolean AND attempts < max_attempts THEN payload ELSE NULL END,
+            error=$4, locked_at=NULL, updated_at=now()
+        WHERE id=$1`, job.ID, retry, backoff(job.Attempts).Seconds(), err.Error())
+    if uerr != nil {
+        log.Printf("job %d fail update error: %v", job.ID, uerr)
+    }
+}
+
+// safeRun turns a handler panic into a failed attempt.
+func safeRun(ctx context.Context, h Handler, job *Job) (result any, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("panic: %v", r)
+        }
+    }()
+    return h(ctx, job)
+}
+
+// backoff doubles the delay after each failed attempt.
+func backoff(attempt int) time.Duration {
+    if attempt < 1 {
+        attempt = 1
+    }
+    if attempt > 8 {
+        attempt = 8
+    }
+    return baseBackoff << (attempt - 1)
+}
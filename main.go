@@ -1,17 +1,41 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
+	"os"
 	"scalingwolf-ai/backend/config"
+	"scalingwolf-ai/backend/controllers"
 	"scalingwolf-ai/backend/database"
+	"scalingwolf-ai/backend/jobs"
+	"scalingwolf-ai/backend/models"
 	"scalingwolf-ai/backend/routes"
+	"strconv"
+	"time"
 )
 
 func main() {
     cfg := config.Load()
     database.Connect(cfg.DatabaseURL)
-    database.EnsureSchema()
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := runMigrate(os.Args[2:]); err != nil {
+            log.Fatalf("migrate: %v", err)
+        }
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "grant-role" {
+        if err := runGrantRole(os.Args[2:]); err != nil {
+            log.Fatalf("grant-role: %v", err)
+        }
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    if err := database.MigrateUp(ctx); err != nil {
+        log.Fatalf("migrations failed, refusing to start: %v", err)
+    }
+    cancel()
     r := gin.Default()
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -24,6 +48,73 @@ func main() {
 		c.Next()
 	})
 	routes.Register(r, cfg)
+	controllers.RegisterJobs(cfg)
+	jobs.Start(context.Background(), cfg.JobWorkers, 2*time.Second)
 	log.Printf("server on :%s", cfg.Port)
 	r.Run(":" + cfg.Port)
 }
+
+// runMigrate implements `migrate up`, `migrate down [steps]` and `migrate status`.
+func runMigrate(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		return database.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		return database.MigrateDown(ctx, steps)
+	case "status":
+		st, err := database.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%04d %-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q (use up, down [steps], status)", cmd)
+	}
+}
+
+// runGrantRole implements `grant-role <email> <user|support|admin>`, the only
+// way to create the first admin.
+func runGrantRole(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: grant-role <email> <user|support|admin>")
+	}
+	email, role := args[0], args[1]
+	if !models.ValidUserRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := database.Pool.Exec(ctx, `UPDATE users SET role=$1 WHERE lower(email)=lower($2)`, role, email)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("no user with email %q", email)
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
@@ -1,10 +1,13 @@
 package middlewares
 
 import (
+	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"scalingwolf-ai/backend/database"
 	"scalingwolf-ai/backend/utils"
 )
 
@@ -17,11 +20,36 @@ func Auth(secret string) gin.HandlerFunc {
 		}
 		t := strings.TrimPrefix(h, "Bearer ")
 		claims, err := utils.ParseJWT(secret, t)
-		if err != nil {
+		if err != nil || claims.SessionID == 0 || claims.OrgID == 0 {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
+		// The signature alone is not enough: the session must still be live
+		// and the user still a member of the org the token was issued for.
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		var role, userRole string
+		var disabled bool
+		err = database.Pool.QueryRow(ctx, `SELECT COALESCE((SELECT role FROM org_members WHERE org_id=$3 AND user_id=$2), ''), u.role, u.disabled_at IS NOT NULL
+FROM sessions s JOIN users u ON u.id=s.user_id
+WHERE s.id=$1 AND s.user_id=$2 AND s.revoked_at IS NULL AND s.expires_at > now()`, claims.SessionID, claims.UserID, claims.OrgID).Scan(&role, &userRole, &disabled)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			return
+		}
+		if disabled {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+			return
+		}
+		if role == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no longer a member of this organization"})
+			return
+		}
 		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
+		c.Set("org_id", claims.OrgID)
+		c.Set("org_role", role)
+		c.Set("user_role", userRole)
 		c.Next()
 	}
 }
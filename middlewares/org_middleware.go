@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"scalingwolf-ai/backend/models"
+)
+
+// RequireOrgRole lets the request through only if the caller's role in the
+// active organization is min or higher. It must run after Auth.
+func RequireOrgRole(min string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.RoleAtLeast(c.GetString("org_role"), min) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires " + min + " role"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"scalingwolf-ai/backend/models"
+)
+
+// RequirePermission lets the request through only if the caller's platform
+// role grants perm. It must run after Auth.
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.HasPermission(c.GetString("user_role"), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing permission " + perm})
+			return
+		}
+		c.Next()
+	}
+}
@@ -13,6 +13,27 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+	Phone string `json:"phone_number"`
+}
+
+type ResetVerifyRequest struct {
+	Email string `json:"email"`
+	Phone string `json:"phone_number"`
+	OTP   string `json:"otp"`
+}
+
+type ResetPasswordRequest struct {
+	ResetToken string `json:"reset_token"`
+	Password   string `json:"password"`
+	Confirm    string `json:"confirm_password"`
+}
+
 type OTPRequest struct {
 	Phone string `json:"phone_number"`
 }
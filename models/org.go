@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// Organization roles, from most to least privileged.
+const (
+    RoleOwner   = "owner"
+    RoleAdmin   = "admin"
+    RoleAnalyst = "analyst"
+    RoleViewer  = "viewer"
+)
+
+var roleRank = map[string]int{RoleOwner: 4, RoleAdmin: 3, RoleAnalyst: 2, RoleViewer: 1}
+
+// ValidRole reports whether r is one of the organization roles.
+func ValidRole(r string) bool {
+    _, ok := roleRank[r]
+    return ok
+}
+
+// RoleAtLeast reports whether role grants everything min grants.
+func RoleAtLeast(role, min string) bool {
+    return roleRank[role] > 0 && roleRank[role] >= roleRank[min]
+}
+
+type Organization struct {
+    ID        int64     `json:"id"`
+    Name      string    `json:"name"`
+    Role      string    `json:"role,omitempty"`
+    Active    bool      `json:"active"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type OrgMember struct {
+    UserID   int64     `json:"user_id"`
+    Name     string    `json:"name"`
+    Email    string    `json:"email"`
+    Role     string    `json:"role"`
+    JoinedAt time.Time `json:"joined_at"`
+}
+
+type OrgInvite struct {
+    ID        int64     `json:"id"`
+    Email     string    `json:"email"`
+    Role      string    `json:"role"`
+    CreatedAt time.Time `json:"created_at"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+type OrgCreateRequest struct {
+    Name string `json:"name"`
+}
+
+type OrgSwitchRequest struct {
+    OrgID int64 `json:"org_id"`
+}
+
+type OrgInviteRequest struct {
+    Email string `json:"email"`
+    Role  string `json:"role"`
+}
+
+type OrgInviteAcceptRequest struct {
+    Token string `json:"token"`
+}
+
+type OrgMemberRoleRequest struct {
+    Role string `json:"role"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// Platform roles stored in users.role. They are independent of organization
+// roles and only gate the admin API.
+const (
+    UserRoleUser    = "user"
+    UserRoleSupport = "support"
+    UserRoleAdmin   = "admin"
+)
+
+// Permissions checked by middlewares.RequirePermission.
+const (
+    PermUsersRead    = "users:read"
+    PermPlansWrite   = "plans:write"
+    PermUsageReset   = "usage:reset"
+    PermUsersDisable = "users:disable"
+)
+
+var rolePermissions = map[string][]string{
+    UserRoleSupport: {PermUsersRead},
+    UserRoleAdmin:   {PermUsersRead, PermPlansWrite, PermUsageReset, PermUsersDisable},
+}
+
+// ValidUserRole reports whether r is a platform role.
+func ValidUserRole(r string) bool {
+    return r == UserRoleUser || r == UserRoleSupport || r == UserRoleAdmin
+}
+
+// HasPermission reports whether the platform role grants perm.
+func HasPermission(role, perm string) bool {
+    for _, p := range rolePermissions[role] {
+        if p == perm {
+            return true
+        }
+    }
+    return false
+}
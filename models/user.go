@@ -17,5 +17,8 @@ type User struct {
     Employees          *int      `json:"employees"`
     GoalAmount         *float64  `json:"goal_amount"`
     GoalYears          *int      `json:"goal_years"`
+    Role               string    `json:"role"`
+    OrgID              int64     `json:"org_id"`
+    OrgRole            string    `json:"org_role"`
     CreatedAt          time.Time `json:"created_at"`
 }
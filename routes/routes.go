@@ -5,6 +5,7 @@ import (
 	"scalingwolf-ai/backend/config"
 	"scalingwolf-ai/backend/controllers"
 	"scalingwolf-ai/backend/middlewares"
+	"scalingwolf-ai/backend/models"
 )
 
 func Register(r *gin.Engine, cfg config.Config) {
@@ -15,29 +16,70 @@ func Register(r *gin.Engine, cfg config.Config) {
         auth.POST("/verify-otp", controllers.VerifyOTP(cfg))
         auth.POST("/register", controllers.Register(cfg))
         auth.POST("/login", controllers.Login(cfg))
+        auth.POST("/refresh", controllers.RefreshToken(cfg))
+        auth.POST("/logout", middlewares.Auth(cfg.JWTSecret), controllers.Logout())
+        auth.POST("/logout-all", middlewares.Auth(cfg.JWTSecret), controllers.LogoutAll())
+        // Forgot password: OTP to WhatsApp, then a single-use reset token
+        auth.POST("/forgot-password", controllers.ForgotPassword(cfg))
+        auth.POST("/forgot-password/verify", controllers.VerifyResetOTP(cfg))
+        auth.POST("/reset-password", controllers.ResetPassword())
 
         priv := api.Group("/")
         priv.Use(middlewares.Auth(cfg.JWTSecret))
-        priv.POST("company/setup", controllers.CompanySetup(cfg))
+        // Role gates within the active organization
+        admin := middlewares.RequireOrgRole(models.RoleAdmin)
+        analyst := middlewares.RequireOrgRole(models.RoleAnalyst)
+        priv.POST("company/setup", admin, controllers.CompanySetup(cfg))
         priv.GET("me", controllers.Me())
+        // Organizations: membership, switching and invites
+        priv.GET("orgs", controllers.ListOrgs())
+        priv.POST("orgs", controllers.CreateOrg())
+        priv.POST("orgs/switch", controllers.SwitchOrg(cfg))
+        priv.POST("orgs/invites/accept", controllers.AcceptOrgInvite())
+        priv.GET("org/members", controllers.ListOrgMembers())
+        priv.PUT("org/members/:user_id", admin, controllers.UpdateOrgMember())
+        priv.DELETE("org/members/:user_id", admin, controllers.RemoveOrgMember())
+        priv.GET("org/invites", admin, controllers.ListOrgInvites())
+        priv.POST("org/invites", admin, controllers.CreateOrgInvite())
+        priv.DELETE("org/invites/:id", admin, controllers.RevokeOrgInvite())
         // Upload and analyze sales/bill file (CSV/XLSX)
-        priv.POST("data/upload-analyze", controllers.UploadAnalyze(cfg))
+        priv.POST("data/upload-analyze", analyst, controllers.UploadAnalyze(cfg))
+        // Reviewed upload: preview detected columns, then confirm or override them
+        priv.POST("data/upload-preview", analyst, controllers.UploadPreview(cfg))
+        priv.POST("data/upload-confirm", analyst, controllers.UploadConfirm())
+        // Saved column mappings, matched to uploads by header names
+        priv.GET("data/mappings", controllers.ListColumnMappings())
+        priv.POST("data/mappings", analyst, controllers.CreateColumnMapping())
+        priv.GET("data/mappings/:id", controllers.GetColumnMapping())
+        priv.PUT("data/mappings/:id", analyst, controllers.UpdateColumnMapping())
+        priv.DELETE("data/mappings/:id", analyst, controllers.DeleteColumnMapping())
+        // Background jobs (uploads): status, progress and result
+        priv.GET("jobs/:id", controllers.GetJob())
         // Sales metrics via text
-        priv.POST("data/sales-text", controllers.IngestSalesText(cfg))
+        priv.POST("data/sales-text", analyst, controllers.IngestSalesText(cfg))
         // Fetch sales metrics (list + single)
         priv.GET("data/sales", controllers.ListSalesMetrics())
         priv.GET("data/sales/latest", controllers.GetLatestSalesMetric())
+        // Daily/weekly/monthly totals from stored transactions
+        priv.GET("data/sales/timeseries", controllers.SalesTimeSeries())
         priv.GET("data/sales/:id", controllers.GetSalesMetric())
+        // Cleaned rows stored for a file upload (paginated)
+        priv.GET("data/sales/:id/rows", controllers.ListSalesRows())
+        // What cleaning left out of an upload, as JSON or a CSV of the rows
+        priv.GET("data/sales/:id/cleaning-report", controllers.GetCleaningReport())
+        priv.GET("data/sales/:id/cleaning-report/excluded.csv", controllers.DownloadExcludedRows())
         // BEP calculation using latest metrics or overrides
-        priv.POST("data/bep/calc", controllers.CalcBEP(cfg))
+        priv.POST("data/bep/calc", analyst, controllers.CalcBEP(cfg))
         priv.GET("data/bep/latest", controllers.GetLatestBEP())
         // RAG: upsert text document
-        priv.POST("rag/upsert-text", controllers.RAGUpsertText(cfg))
+        priv.POST("rag/upsert-text", analyst, controllers.RAGUpsertText(cfg))
         // RAG: upsert chunked long text and search
-        priv.POST("rag/upsert-chunks", controllers.RAGUpsertChunks(cfg))
+        priv.POST("rag/upsert-chunks", analyst, controllers.RAGUpsertChunks(cfg))
         priv.POST("rag/search", controllers.RAGSearch(cfg))
         // Chat: send message (creates chat if needed)
         priv.POST("chat/send", controllers.ChatSend(cfg))
+        // Chat: same as send, but streams the reply as Server-Sent Events
+        priv.POST("chat/send/stream", controllers.ChatSendStream(cfg))
         // Chat management
         priv.POST("chat/new", controllers.ChatCreate())
         priv.GET("chat", controllers.ChatList())
@@ -46,6 +88,15 @@ func Register(r *gin.Engine, cfg config.Config) {
         priv.DELETE("chat/:id", controllers.ChatDelete())
         // Token quotas
         priv.GET("tokens/usage", controllers.TokensUsage())
-        priv.POST("tokens/set-plan", controllers.TokensSetPlan())
+        priv.GET("plans", controllers.ListPlans())
+
+        // Platform admin API; each route names the permission it needs
+        adm := api.Group("/admin")
+        adm.Use(middlewares.Auth(cfg.JWTSecret))
+        adm.GET("/users", middlewares.RequirePermission(models.PermUsersRead), controllers.AdminListUsers())
+        adm.PUT("/users/:id/plan", middlewares.RequirePermission(models.PermPlansWrite), controllers.AdminSetPlan())
+        adm.POST("/users/:id/reset-usage", middlewares.RequirePermission(models.PermUsageReset), controllers.AdminResetUsage())
+        adm.POST("/users/:id/disable", middlewares.RequirePermission(models.PermUsersDisable), controllers.AdminDisableUser())
+        adm.POST("/users/:id/enable", middlewares.RequirePermission(models.PermUsersDisable), controllers.AdminEnableUser())
     }
 }
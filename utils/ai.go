@@ -2,34 +2,58 @@ package utils
 
 import (
     "context"
+    "fmt"
     "strconv"
     "strings"
+)
 
-    "github.com/google/generative-ai-go/genai"
-    "google.golang.org/api/option"
+// Provider names accepted in AIConfig.Provider.
+const (
+    ProviderGemini = "gemini"
+    ProviderFake   = "fake"
 )
 
 type AIConfig struct {
+    Provider     string
     APIKey       string
     GenModel     string
     EmbedModel   string
 }
 
-func NewAIClient(ctx context.Context, cfg AIConfig) (*genai.Client, error) {
-    return genai.NewClient(ctx, option.WithAPIKey(cfg.APIKey))
+// Usage is the token accounting reported by a provider for one generation.
+type Usage struct {
+    PromptTokens int64
+    OutputTokens int64
+    TotalTokens  int64
 }
 
-func EmbedText(ctx context.Context, client *genai.Client, embedModel, text string) ([]float32, error) {
-    m := client.EmbeddingModel(embedModel)
-    resp, err := m.EmbedContent(ctx, genai.Text(text))
-    if err != nil || resp == nil || resp.Embedding == nil {
-        return nil, err
-    }
-    vec := make([]float32, len(resp.Embedding.Values))
-    for i, v := range resp.Embedding.Values {
-        vec[i] = float32(v)
+// Provider is the vendor-neutral surface the controllers talk to. Prompts are
+// passed as plain text parts, in order, exactly as they would be sent to the model.
+type Provider interface {
+    Generate(ctx context.Context, parts ...string) (string, error)
+    GenerateWithUsage(ctx context.Context, parts ...string) (string, Usage, error)
+    // GenerateStream calls onDelta with each text fragment as it arrives and
+    // returns the full reply and final usage once the stream ends.
+    GenerateStream(ctx context.Context, onDelta func(string) error, parts ...string) (string, Usage, error)
+    Embed(ctx context.Context, text string) ([]float32, error)
+    BatchEmbed(ctx context.Context, texts []string) ([][]float32, error)
+    Close() error
+}
+
+// NewAIClient returns the provider selected by cfg.Provider (defaults to Gemini).
+func NewAIClient(ctx context.Context, cfg AIConfig) (Provider, error) {
+    switch strings.ToLower(strings.TrimSpace(cfg.Provider)) {
+    case "", ProviderGemini:
+        return newGeminiProvider(ctx, cfg)
+    case ProviderFake:
+        return NewFakeProvider(), nil
+    default:
+        return nil, fmt.Errorf("unknown ai provider %q", cfg.Provider)
     }
-    return vec, nil
+}
+
+func EmbedText(ctx context.Context, client Provider, text string) ([]float32, error) {
+    return client.Embed(ctx, text)
 }
 
 func VectorLiteral(v []float32) string {
@@ -41,23 +65,10 @@ func VectorLiteral(v []float32) string {
     return "[" + strings.Join(parts, ",") + "]"
 }
 
-func GenerateText(ctx context.Context, client *genai.Client, model string, parts ...genai.Part) (string, error) {
-    m := client.GenerativeModel(model)
-    resp, err := m.GenerateContent(ctx, parts...)
+func GenerateText(ctx context.Context, client Provider, parts ...string) (string, error) {
+    text, err := client.Generate(ctx, parts...)
     if err != nil {
         return "", err
     }
-    var b strings.Builder
-    if resp != nil {
-        for _, c := range resp.Candidates {
-            if c == nil || c.Content == nil { continue }
-            for _, p := range c.Content.Parts {
-                if t, ok := p.(genai.Text); ok {
-                    b.WriteString(string(t))
-                }
-            }
-        }
-    }
-    return strings.TrimSpace(b.String()), nil
+    return strings.TrimSpace(text), nil
 }
-
@@ -0,0 +1,113 @@
+package utils
+
+import (
+    "context"
+    "hash/fnv"
+    "math"
+    "strings"
+)
+
+// FakeEmbeddingDims matches the vector(768) column used by rag_documents.
+const FakeEmbeddingDims = 768
+
+// FakeProvider is a deterministic, offline Provider. The same input always
+// yields the same reply, usage and embedding, so full request flows can run
+// without network access. Set Respond to script replies for specific prompts.
+type FakeProvider struct {
+    Respond func(parts []string) string
+}
+
+func NewFakeProvider() *FakeProvider {
+    return &FakeProvider{}
+}
+
+func (f *FakeProvider) Generate(ctx context.Context, parts ...string) (string, error) {
+    text, _, err := f.GenerateWithUsage(ctx, parts...)
+    return text, err
+}
+
+func (f *FakeProvider) GenerateWithUsage(ctx context.Context, parts ...string) (string, Usage, error) {
+    if err := ctx.Err(); err != nil {
+        return "", Usage{}, err
+    }
+    var reply string
+    if f.Respond != nil {
+        reply = f.Respond(parts)
+    } else {
+        last := ""
+        if len(parts) > 0 {
+            last = parts[len(parts)-1]
+        }
+        reply = "fake reply (" + strings.Join(strings.Fields(last), " ") + ")"
+    }
+    in := EstimateTokens(strings.Join(parts, "\n"))
+    out := EstimateTokens(reply)
+    return reply, Usage{PromptTokens: in, OutputTokens: out, TotalTokens: in + out}, nil
+}
+
+// GenerateStream emits the deterministic reply one word at a time.
+func (f *FakeProvider) GenerateStream(ctx context.Context, onDelta func(string) error, parts ...string) (string, Usage, error) {
+    reply, usage, err := f.GenerateWithUsage(ctx, parts...)
+    if err != nil {
+        return "", Usage{}, err
+    }
+    for i, w := range strings.Fields(reply) {
+        if i > 0 {
+            w = " " + w
+        }
+        if onDelta != nil {
+            if err := onDelta(w); err != nil {
+                return reply, usage, err
+            }
+        }
+    }
+    return reply, usage, nil
+}
+
+// Embed hashes word tokens into a fixed number of buckets and L2-normalises
+// the result, so texts sharing words land close together.
+func (f *FakeProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    vec := make([]float32, FakeEmbeddingDims)
+    for _, w := range strings.Fields(strings.ToLower(text)) {
+        h := fnv.New32a()
+        h.Write([]byte(w))
+        vec[h.Sum32()%FakeEmbeddingDims] += 1
+    }
+    var norm float64
+    for _, v := range vec {
+        norm += float64(v) * float64(v)
+    }
+    if norm > 0 {
+        n := float32(math.Sqrt(norm))
+        for i := range vec {
+            vec[i] /= n
+        }
+    }
+    return vec, nil
+}
+
+func (f *FakeProvider) BatchEmbed(ctx context.Context, texts []string) ([][]float32, error) {
+    out := make([][]float32, len(texts))
+    for i, t := range texts {
+        v, err := f.Embed(ctx, t)
+        if err != nil {
+            return nil, err
+        }
+        out[i] = v
+    }
+    return out, nil
+}
+
+func (f *FakeProvider) Close() error { return nil }
+
+// EstimateTokens approximates a token count at ~4 characters per token.
+func EstimateTokens(s string) int64 {
+    n := int64(len(strings.TrimSpace(s)))
+    if n == 0 {
+        return 0
+    }
+    return (n + 3) / 4
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+    "context"
+    "errors"
+    "strings"
+
+    "github.com/google/generative-ai-go/genai"
+    "google.golang.org/api/iterator"
+    "google.golang.org/api/option"
+)
+
+type geminiProvider struct {
+    client     *genai.Client
+    genModel   string
+    embedModel string
+}
+
+func newGeminiProvider(ctx context.Context, cfg AIConfig) (*geminiProvider, error) {
+    client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.APIKey))
+    if err != nil {
+        return nil, err
+    }
+    return &geminiProvider{client: client, genModel: cfg.GenModel, embedModel: cfg.EmbedModel}, nil
+}
+
+func (g *geminiProvider) Generate(ctx context.Context, parts ...string) (string, error) {
+    text, _, err := g.GenerateWithUsage(ctx, parts...)
+    return text, err
+}
+
+func (g *geminiProvider) GenerateWithUsage(ctx context.Context, parts ...string) (string, Usage, error) {
+    m := g.client.GenerativeModel(g.genModel)
+    resp, err := m.GenerateContent(ctx, textParts(parts)...)
+    if err != nil {
+        return "", Usage{}, err
+    }
+    return responseText(resp), responseUsage(resp), nil
+}
+
+func (g *geminiProvider) GenerateStream(ctx context.Context, onDelta func(string) error, parts ...string) (string, Usage, error) {
+    m := g.client.GenerativeModel(g.genModel)
+    iter := m.GenerateContentStream(ctx, textParts(parts)...)
+    var b strings.Builder
+    for {
+        resp, err := iter.Next()
+        if err == iterator.Done {
+            break
+        }
+        if err != nil {
+            return strings.TrimSpace(b.String()), responseUsage(iter.MergedResponse()), err
+        }
+        delta := rawResponseText(resp)
+        if delta == "" {
+            continue
+        }
+        b.WriteString(delta)
+        if onDelta != nil {
+            if err := onDelta(delta); err != nil {
+                return strings.TrimSpace(b.String()), responseUsage(iter.MergedResponse()), err
+            }
+        }
+    }
+    return strings.TrimSpace(b.String()), responseUsage(iter.MergedResponse()), nil
+}
+
+func (g *geminiProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+    m := g.client.EmbeddingModel(g.embedModel)
+    resp, err := m.EmbedContent(ctx, genai.Text(text))
+    if err != nil {
+        return nil, err
+    }
+    if resp == nil || resp.Embedding == nil {
+        return nil, errors.New("empty embedding response")
+    }
+    return resp.Embedding.Values, nil
+}
+
+func (g *geminiProvider) BatchEmbed(ctx context.Context, texts []string) ([][]float32, error) {
+    if len(texts) == 0 {
+        return nil, nil
+    }
+    m := g.client.EmbeddingModel(g.embedModel)
+    b := m.NewBatch()
+    for _, t := range texts {
+        b.AddContent(genai.Text(t))
+    }
+    resp, err := m.BatchEmbedContents(ctx, b)
+    if err != nil {
+        return nil, err
+    }
+    if resp == nil || len(resp.Embeddings) != len(texts) {
+        return nil, errors.New("embedding batch size mismatch")
+    }
+    out := make([][]float32, len(resp.Embeddings))
+    for i, e := range resp.Embeddings {
+        if e == nil {
+            return nil, errors.New("empty embedding in batch")
+        }
+        out[i] = e.Values
+    }
+    return out, nil
+}
+
+func (g *geminiProvider) Close() error {
+    return g.client.Close()
+}
+
+func textParts(parts []string) []genai.Part {
+    out := make([]genai.Part, len(parts))
+    for i, p := range parts {
+        out[i] = genai.Text(p)
+    }
+    return out
+}
+
+func responseText(resp *genai.GenerateContentResponse) string {
+    return strings.TrimSpace(rawResponseText(resp))
+}
+
+// rawResponseText concatenates text parts without trimming, so streamed
+// fragments keep the whitespace between them.
+func rawResponseText(resp *genai.GenerateContentResponse) string {
+    var b strings.Builder
+    if resp != nil {
+        for _, c := range resp.Candidates {
+            if c == nil || c.Content == nil { continue }
+            for _, p := range c.Content.Parts {
+                if t, ok := p.(genai.Text); ok {
+                    b.WriteString(string(t))
+                }
+            }
+        }
+    }
+    return b.String()
+}
+
+func responseUsage(resp *genai.GenerateContentResponse) Usage {
+    if resp == nil || resp.UsageMetadata == nil {
+        return Usage{}
+    }
+    return Usage{
+        PromptTokens: int64(resp.UsageMetadata.PromptTokenCount),
+        OutputTokens: int64(resp.UsageMetadata.CandidatesTokenCount),
+        TotalTokens:  int64(resp.UsageMetadata.TotalTokenCount),
+    }
+}
@@ -0,0 +1,250 @@
+package utils
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "unicode/utf8"
+
+    "golang.org/x/text/encoding"
+    "golang.org/x/text/encoding/charmap"
+    "golang.org/x/text/encoding/unicode"
+)
+
+// CSVDialect is how a delimited text upload was written, as detected by ReadCSV.
+type CSVDialect struct {
+    Delimiter string `json:"delimiter"`
+    Quote     string `json:"quote"`
+    Encoding  string `json:"encoding"` // utf-8, utf-16le, utf-16be or windows-1252
+    BOM       bool   `json:"bom"`
+}
+
+// sniffBytes is how much of the file the dialect is guessed from.
+const sniffBytes = 16 * 1024
+
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// ReadCSV detects the encoding and dialect of a delimited text file,
+// transcodes it to UTF-8 and splits it into rows.
+func ReadCSV(content []byte) ([][]string, CSVDialect, error) {
+    text, d, err := decodeText(content)
+    if err != nil {
+        return nil, d, err
+    }
+    d.Delimiter, d.Quote = sniffDialect(text)
+    rows := splitDelimited(text, []rune(d.Delimiter)[0], []rune(d.Quote)[0])
+    return rows, d, nil
+}
+
+// decodeText strips any BOM and transcodes content to UTF-8.
+func decodeText(content []byte) (string, CSVDialect, error) {
+    d := CSVDialect{Encoding: "utf-8"}
+    var dec *encoding.Decoder
+    switch {
+    case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+        d.BOM = true
+        content = content[3:]
+    case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+        d.BOM, d.Encoding = true, "utf-16le"
+        content = content[2:]
+    case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+        d.BOM, d.Encoding = true, "utf-16be"
+        content = content[2:]
+    default:
+        d.Encoding = guessEncoding(content)
+    }
+    switch d.Encoding {
+    case "utf-16le":
+        dec = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+    case "utf-16be":
+        dec = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+    case "windows-1252":
+        dec = charmap.Windows1252.NewDecoder()
+    default:
+        return string(content), d, nil
+    }
+    out, err := dec.Bytes(content)
+    if err != nil {
+        return "", d, errors.New("could not decode " + d.Encoding + " text")
+    }
+    return string(out), d, nil
+}
+
+// guessEncoding tells BOM-less UTF-16 by its NUL pattern, then UTF-8 by
+// validity, and otherwise assumes Windows-1252, which POS exports use most.
+func guessEncoding(content []byte) string {
+    head := content
+    if len(head) > sniffBytes {
+        head = head[:sniffBytes]
+    }
+    if le, be := utf16Zeros(head); le || be {
+        if le {
+            return "utf-16le"
+        }
+        return "utf-16be"
+    }
+    // A cut at the sample's end may split a multi-byte rune.
+    if len(head) < len(content) {
+        for i := 0; i < 3 && !utf8.Valid(head); i++ {
+            head = head[:len(head)-1]
+        }
+    }
+    if utf8.Valid(head) {
+        return "utf-8"
+    }
+    return "windows-1252"
+}
+
+// utf16Zeros reports whether head looks like ASCII-range UTF-16 text: most
+// odd bytes NUL (little endian) or most even bytes NUL (big endian).
+func utf16Zeros(head []byte) (le, be bool) {
+    if len(head) < 4 {
+        return false, false
+    }
+    even, odd := 0, 0
+    for i, b := range head {
+        if b != 0 {
+            continue
+        }
+        if i%2 == 0 {
+            even++
+        } else {
+            odd++
+        }
+    }
+    half := len(head) / 2
+    return odd*10 >= half*7 && even*10 < half, even*10 >= half*7 && odd*10 < half
+}
+
+// sniffDialect picks the delimiter whose per-line count is most consistent
+// over the first lines, and the quote character seen around delimiters.
+func sniffDialect(text string) (delim, quote string) {
+    if len(text) > sniffBytes {
+        text = text[:sniffBytes]
+    }
+    lines := make([]string, 0, 20)
+    for _, l := range strings.Split(text, "\n") {
+        if strings.TrimSpace(l) == "" {
+            continue
+        }
+        lines = append(lines, strings.TrimRight(l, "\r"))
+        if len(lines) == 20 {
+            break
+        }
+    }
+    // A cut-off last line would skew the counts.
+    if len(lines) > 1 && len(text) == sniffBytes {
+        lines = lines[:len(lines)-1]
+    }
+    quote = `"`
+    if strings.Count(text, "'") > 0 && strings.Count(text, `"`) == 0 {
+        quote = "'"
+    }
+    q := []rune(quote)[0]
+    best, bestScore := ',', 0.0
+    for _, d := range csvDelimiters {
+        counts := map[int]int{}
+        for _, l := range lines {
+            counts[countOutsideQuotes(l, d, q)]++
+        }
+        mode, modeLines := 0, 0
+        for n, c := range counts {
+            if n > 0 && (c > modeLines || (c == modeLines && n > mode)) {
+                mode, modeLines = n, c
+            }
+        }
+        if mode == 0 {
+            continue
+        }
+        // Consistency first; more columns breaks ties between equally steady candidates.
+        score := float64(modeLines)/float64(len(lines)) + float64(mode)/1000
+        if score > bestScore {
+            best, bestScore = d, score
+        }
+    }
+    if quote == "'" && !quotesFramed(lines, best, '\'') {
+        quote = `"` // apostrophes in text ("Joe's") are not quoting
+    }
+    return string(best), quote
+}
+
+func countOutsideQuotes(line string, d, q rune) int {
+    n, in := 0, false
+    for _, r := range line {
+        switch {
+        case r == q:
+            in = !in
+        case r == d && !in:
+            n++
+        }
+    }
+    return n
+}
+
+// quotesFramed reports whether q appears at field edges, as a quote would.
+func quotesFramed(lines []string, d, q rune) bool {
+    qs, ds := string(q), string(d)
+    for _, l := range lines {
+        if strings.HasPrefix(l, qs) || strings.Contains(l, ds+qs) || strings.Contains(l, qs+ds) {
+            return true
+        }
+    }
+    return false
+}
+
+// splitDelimited parses RFC 4180-style records with the given delimiter and
+// quote; quoted fields may hold delimiters, newlines and doubled quotes.
+// Malformed quoting is kept as literal text rather than failing the file.
+func splitDelimited(text string, d, q rune) [][]string {
+    var rows [][]string
+    var row []string
+    var field strings.Builder
+    in, quoted := false, false
+    rs := []rune(text)
+    endRow := func() {
+        row = append(row, field.String())
+        field.Reset()
+        quoted = false
+        if len(row) > 1 || row[0] != "" {
+            rows = append(rows, row)
+        }
+        row = nil
+    }
+    for i := 0; i < len(rs); i++ {
+        r := rs[i]
+        if in {
+            if r == q {
+                if i+1 < len(rs) && rs[i+1] == q {
+                    field.WriteRune(q)
+                    i++
+                } else {
+                    in = false
+                }
+                continue
+            }
+            field.WriteRune(r)
+            continue
+        }
+        switch {
+        case r == q && field.Len() == 0 && !quoted:
+            in, quoted = true, true
+        case r == d:
+            row = append(row, field.String())
+            field.Reset()
+            quoted = false
+        case r == '\n':
+            endRow()
+        case r == '\r':
+            if i+1 < len(rs) && rs[i+1] == '\n' {
+                continue
+            }
+            endRow()
+        default:
+            field.WriteRune(r)
+        }
+    }
+    if field.Len() > 0 || len(row) > 0 {
+        endRow()
+    }
+    return rows
+}
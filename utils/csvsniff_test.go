@@ -0,0 +1,95 @@
+package utils
+
+import (
+    "reflect"
+    "testing"
+)
+
+// utf16Bytes encodes ASCII s as UTF-16 without a BOM.
+func utf16Bytes(s string, littleEndian bool) []byte {
+    out := make([]byte, 0, 2*len(s))
+    for _, b := range []byte(s) {
+        if littleEndian {
+            out = append(out, b, 0)
+        } else {
+            out = append(out, 0, b)
+        }
+    }
+    return out
+}
+
+func TestReadCSVEncodings(t *testing.T) {
+    const text = "Bill,Amount\r\nB1,100\r\nB2,250\r\n"
+    want := [][]string{{"Bill", "Amount"}, {"B1", "100"}, {"B2", "250"}}
+    cases := []struct {
+        name     string
+        content  []byte
+        encoding string
+        bom      bool
+    }{
+        {"utf-8", []byte(text), "utf-8", false},
+        {"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, text...), "utf-8", true},
+        {"utf-16le bom", append([]byte{0xFF, 0xFE}, utf16Bytes(text, true)...), "utf-16le", true},
+        {"utf-16be bom", append([]byte{0xFE, 0xFF}, utf16Bytes(text, false)...), "utf-16be", true},
+        {"utf-16le no bom", utf16Bytes(text, true), "utf-16le", false},
+        {"utf-16be no bom", utf16Bytes(text, false), "utf-16be", false},
+    }
+    for _, tc := range cases {
+        rows, d, err := ReadCSV(tc.content)
+        if err != nil {
+            t.Fatalf("%s: ReadCSV: %v", tc.name, err)
+        }
+        if d.Encoding != tc.encoding || d.BOM != tc.bom || d.Delimiter != "," {
+            t.Errorf("%s: dialect = %+v, want encoding %s, bom %v", tc.name, d, tc.encoding, tc.bom)
+        }
+        if !reflect.DeepEqual(rows, want) {
+            t.Errorf("%s: rows = %q, want %q", tc.name, rows, want)
+        }
+    }
+}
+
+func TestReadCSVWindows1252Fallback(t *testing.T) {
+    // 0xE9 is "é" in Windows-1252 and invalid on its own in UTF-8
+    rows, d, err := ReadCSV([]byte("Item;Amount\nCaf\xe9;3,50\n"))
+    if err != nil {
+        t.Fatalf("ReadCSV: %v", err)
+    }
+    if d.Encoding != "windows-1252" || d.BOM {
+        t.Fatalf("dialect = %+v, want windows-1252 without BOM", d)
+    }
+    if rows[1][0] != "Café" {
+        t.Fatalf("rows[1][0] = %q, want Café", rows[1][0])
+    }
+}
+
+func TestSniffDialect(t *testing.T) {
+    cases := []struct {
+        name  string
+        text  string
+        delim string
+        quote string
+    }{
+        {"comma", "a,b,c\n1,2,3\n4,5,6\n", ",", `"`},
+        // Decimal commas must not outvote the steady semicolons
+        {"semicolon", "Bill;Amount;Date\nB1;1,50;01.02.2024\nB2;12,75;02.02.2024\n", ";", `"`},
+        {"tab", "Bill\tAmount\tNote\nB1\t100\tx, y\nB2\t200\tz\n", "\t", `"`},
+        {"pipe", "Bill|Amount\nB1|100\nB2|200\n", "|", `"`},
+        {"quoted delimiters", "Item,Amount\n\"Tea, large\",40\n\"Cake, slice\",80\n", ",", `"`},
+        {"single quotes", "'Item','Amount'\n'Tea, large','40'\n", ",", "'"},
+        {"apostrophes", "Item,Amount\nJoe's tea,40\nAnn's cake,80\n", ",", `"`},
+    }
+    for _, tc := range cases {
+        delim, quote := sniffDialect(tc.text)
+        if delim != tc.delim || quote != tc.quote {
+            t.Errorf("%s: sniffDialect = %q, %q; want %q, %q", tc.name, delim, quote, tc.delim, tc.quote)
+        }
+    }
+}
+
+func TestSplitDelimitedQuoting(t *testing.T) {
+    rows := splitDelimited("a,\"b \"\"x\"\", c\nd\",e\r\n\r\nf,g", ',', '"')
+    want := [][]string{{"a", "b \"x\", c\nd", "e"}, {"f", "g"}}
+    if !reflect.DeepEqual(rows, want) {
+        t.Fatalf("rows = %q, want %q", rows, want)
+    }
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// excelEpoch is day 0 of the 1900 date system as Excel counts it (the
+// off-by-two from 1900-01-01 covers Excel's phantom 1900-02-29).
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Serial numbers outside this window are treated as plain numbers, not dates
+// (roughly 1954-10-03 to 2119-01-08).
+const (
+    minExcelSerial = 20000
+    maxExcelSerial = 80000
+)
+
+var numericDateRe = regexp.MustCompile(`^(\d{1,4})[/\-.](\d{1,2})[/\-.](\d{1,4})`)
+
+// Layouts tried after the numeric d/m/y forms, most specific first.
+var dateLayouts = []string{
+    time.RFC3339,
+    "2006-01-02T15:04:05",
+    "2006-01-02 15:04:05",
+    "2006-01-02 15:04",
+    "2006-01-02",
+    "02-Jan-2006 15:04:05",
+    "02-Jan-2006",
+    "2-Jan-2006",
+    "02-Jan-06",
+    "2-Jan-06",
+    "02 Jan 2006",
+    "2 Jan 2006",
+    "02 January 2006",
+    "2 January 2006",
+    "Jan 2, 2006",
+    "January 2, 2006",
+    "Jan 2 2006",
+    "Mon, 02 Jan 2006",
+}
+
+// DayFirst reports whether ambiguous numeric dates such as 03/04/2024 in the
+// sample should be read as day/month. It returns true unless some value can
+// only be month/day, matching the default for Indian exports.
+func DayFirst(samples []string) bool {
+    monthFirst, dayFirst := 0, 0
+    for _, s := range samples {
+        m := numericDateRe.FindStringSubmatch(strings.TrimSpace(s))
+        if m == nil || len(m[1]) == 4 {
+            continue
+        }
+        a, _ := strconv.Atoi(m[1])
+        b, _ := strconv.Atoi(m[2])
+        if a > 12 && b <= 12 {
+            dayFirst++
+        } else if b > 12 && a <= 12 {
+            monthFirst++
+        }
+    }
+    return !(monthFirst > 0 && dayFirst == 0)
+}
+
+// ParseDate parses common spreadsheet date renderings, including Excel serial
+// day numbers. Time-of-day is dropped; the result is a UTC midnight.
+func ParseDate(s string, dayFirst bool) (time.Time, bool) {
+    t := strings.TrimSpace(s)
+    if t == "" {
+        return time.Time{}, false
+    }
+    if f, err := strconv.ParseFloat(t, 64); err == nil {
+        if f < minExcelSerial || f > maxExcelSerial {
+            return time.Time{}, false
+        }
+        return excelEpoch.AddDate(0, 0, int(math.Floor(f))), true
+    }
+    if m := numericDateRe.FindStringSubmatch(t); m != nil {
+        a, _ := strconv.Atoi(m[1])
+        b, _ := strconv.Atoi(m[2])
+        c, _ := strconv.Atoi(m[3])
+        var y, mo, d int
+        switch {
+        case len(m[1]) == 4:
+            y, mo, d = a, b, c
+        case dayFirst:
+            d, mo, y = a, b, c
+        default:
+            mo, d, y = a, b, c
+        }
+        if len(m[3]) == 2 && len(m[1]) != 4 {
+            y += 2000
+            if y > time.Now().Year()+20 {
+                y -= 100
+            }
+        }
+        if mo >= 1 && mo <= 12 && d >= 1 && d <= 31 && y >= 1900 && y <= 2200 {
+            out := time.Date(y, time.Month(mo), d, 0, 0, 0, 0, time.UTC)
+            if out.Day() == d {
+                return out, true
+            }
+        }
+        return time.Time{}, false
+    }
+    for _, layout := range dateLayouts {
+        if p, err := time.Parse(layout, t); err == nil {
+            return time.Date(p.Year(), p.Month(), p.Day(), 0, 0, 0, 0, time.UTC), true
+        }
+    }
+    return time.Time{}, false
+}
+
+// WeekStart returns the Monday of d's ISO week, matching Postgres date_trunc('week').
+func WeekStart(d time.Time) time.Time {
+    offset := (int(d.Weekday()) + 6) % 7
+    return d.AddDate(0, 0, -offset)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+    "bytes"
+    "unicode/utf8"
+)
+
+var (
+    oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+    zipMagic = []byte("PK\x03\x04")
+)
+
+// DetectSpreadsheet names the format of an uploaded table by its leading
+// bytes rather than its filename: ".xls" for OLE2 (Excel 97-2003), ".xlsx"
+// for a zip container, ".csv" for text, or "" when it is none of these.
+func DetectSpreadsheet(content []byte) string {
+    switch {
+    case bytes.HasPrefix(content, oleMagic):
+        return ".xls"
+    case bytes.HasPrefix(content, zipMagic):
+        return ".xlsx"
+    case looksLikeText(content):
+        return ".csv"
+    }
+    return ""
+}
+
+func looksLikeText(content []byte) bool {
+    head := content
+    if len(head) > 4096 {
+        head = head[:4096]
+    }
+    // UTF-16 text is full of NULs: accept it by BOM or by where the NULs fall.
+    if bytes.HasPrefix(head, []byte{0xFF, 0xFE}) || bytes.HasPrefix(head, []byte{0xFE, 0xFF}) {
+        return true
+    }
+    if le, be := utf16Zeros(head); le || be {
+        return true
+    }
+    if bytes.IndexByte(head, 0) >= 0 {
+        return false
+    }
+    if utf8.Valid(head) {
+        return true
+    }
+    // Single-byte code pages (Windows-1252 and friends): mostly printable.
+    ctrl := 0
+    for _, b := range head {
+        if b < 0x20 && b != '\n' && b != '\r' && b != '\t' {
+            ctrl++
+        }
+    }
+    return ctrl*20 < len(head)
+}
@@ -1,22 +1,26 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
 type Claims struct {
-	UserID int64 `json:"user_id"`
+	UserID    int64 `json:"user_id"`
+	SessionID int64 `json:"sid"`
+	OrgID     int64 `json:"org_id"` // active organization
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(secret string, uid int64, ttl time.Duration) (string, error) {
-	claims := Claims{
-		UserID: uid,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// GenerateJWT signs an HS256 access token for claims, valid for ttl.
+func GenerateJWT(secret string, claims Claims, ttl time.Duration) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
 	}
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return t.SignedString([]byte(secret))
@@ -26,6 +30,23 @@ func ParseJWT(secret, token string) (*Claims, error) {
 	claims := &Claims{}
 	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
 		return []byte(secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	return claims, err
 }
+
+// NewOpaqueToken returns a random URL-safe token and the hash to store for it.
+// Only the hash is persisted, so a database leak does not expose usable tokens.
+func NewOpaqueToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken is the lookup hash for an opaque token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+    "context"
+    "errors"
+)
+
+// ErrQuotaExceeded is returned by a metered provider when the Meter refuses
+// a call because the caller's token budget is used up.
+var ErrQuotaExceeded = errors.New("token quota exceeded")
+
+// ErrQuotaBusy is returned when the caller already has too many model calls
+// in flight to reserve budget for another one.
+var ErrQuotaBusy = errors.New("too many concurrent model calls")
+
+// outputAllowance is the number of reply tokens reserved for a generate call
+// on top of its estimated prompt.
+const outputAllowance = 2048
+
+// Call kinds recorded by the metering layer.
+const (
+    CallGenerate = "generate"
+    CallEmbed    = "embed"
+)
+
+// MeteredCall describes one model call for accounting.
+type MeteredCall struct {
+    Feature   string
+    Kind      string // CallGenerate or CallEmbed
+    Usage     Usage
+    Estimated bool // usage was estimated locally because the provider reported none
+}
+
+// Meter reserves budget before a call so concurrent calls cannot overspend.
+type Meter interface {
+    // Reserve holds an estimated number of tokens for one call. It returns an
+    // error wrapping ErrQuotaExceeded or ErrQuotaBusy to block the call.
+    Reserve(ctx context.Context, feature string, tokens int64) (Reservation, error)
+}
+
+// Reservation is a budget hold for one call. Exactly one of Settle or
+// Release must be called.
+type Reservation interface {
+    // Settle charges the call's actual usage and frees the hold.
+    Settle(call MeteredCall)
+    // Release frees the hold without charging, for calls that failed.
+    Release()
+}
+
+type featureKey struct{}
+
+// WithFeature labels calls made with ctx, overriding the provider's default
+// feature. Useful when one client serves several purposes in a request.
+func WithFeature(ctx context.Context, feature string) context.Context {
+    return context.WithValue(ctx, featureKey{}, feature)
+}
+
+// Metered wraps p so every call reserves budget from m first and settles it
+// afterwards under feature (or the one set with WithFeature). Embedding APIs
+// report no usage, so their tokens are estimated from the input text.
+func Metered(p Provider, m Meter, feature string) Provider {
+    if m == nil {
+        return p
+    }
+    return &meteredProvider{inner: p, meter: m, feature: feature}
+}
+
+type meteredProvider struct {
+    inner   Provider
+    meter   Meter
+    feature string
+}
+
+func (p *meteredProvider) featureOf(ctx context.Context) string {
+    if f, ok := ctx.Value(featureKey{}).(string); ok && f != "" {
+        return f
+    }
+    return p.feature
+}
+
+func estimateParts(parts []string) int64 {
+    var n int64
+    for _, s := range parts {
+        n += EstimateTokens(s)
+    }
+    return n
+}
+
+func settleGenerate(r Reservation, feature string, parts []string, reply string, u Usage) {
+    estimated := false
+    if u.TotalTokens <= 0 && u.PromptTokens <= 0 && u.OutputTokens <= 0 {
+        u.PromptTokens = estimateParts(parts)
+        u.OutputTokens = EstimateTokens(reply)
+        estimated = true
+    }
+    if u.TotalTokens <= 0 {
+        u.TotalTokens = u.PromptTokens + u.OutputTokens
+    }
+    r.Settle(MeteredCall{Feature: feature, Kind: CallGenerate, Usage: u, Estimated: estimated})
+}
+
+func settleEmbed(r Reservation, feature string, texts []string) {
+    n := estimateParts(texts)
+    r.Settle(MeteredCall{Feature: feature, Kind: CallEmbed, Usage: Usage{PromptTokens: n, TotalTokens: n}, Estimated: true})
+}
+
+func (p *meteredProvider) Generate(ctx context.Context, parts ...string) (string, error) {
+    text, _, err := p.GenerateWithUsage(ctx, parts...)
+    return text, err
+}
+
+func (p *meteredProvider) GenerateWithUsage(ctx context.Context, parts ...string) (string, Usage, error) {
+    feature := p.featureOf(ctx)
+    r, err := p.meter.Reserve(ctx, feature, estimateParts(parts)+outputAllowance)
+    if err != nil {
+        return "", Usage{}, err
+    }
+    text, u, err := p.inner.GenerateWithUsage(ctx, parts...)
+    if err != nil {
+        r.Release()
+        return text, u, err
+    }
+    settleGenerate(r, feature, parts, text, u)
+    return text, u, nil
+}
+
+func (p *meteredProvider) GenerateStream(ctx context.Context, onDelta func(string) error, parts ...string) (string, Usage, error) {
+    feature := p.featureOf(ctx)
+    r, err := p.meter.Reserve(ctx, feature, estimateParts(parts)+outputAllowance)
+    if err != nil {
+        return "", Usage{}, err
+    }
+    text, u, err := p.inner.GenerateStream(ctx, onDelta, parts...)
+    // A stream cut short still consumed the tokens sent so far.
+    if err == nil || text != "" {
+        settleGenerate(r, feature, parts, text, u)
+    } else {
+        r.Release()
+    }
+    return text, u, err
+}
+
+func (p *meteredProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+    feature := p.featureOf(ctx)
+    r, err := p.meter.Reserve(ctx, feature, EstimateTokens(text))
+    if err != nil {
+        return nil, err
+    }
+    v, err := p.inner.Embed(ctx, text)
+    if err != nil {
+        r.Release()
+        return nil, err
+    }
+    settleEmbed(r, feature, []string{text})
+    return v, nil
+}
+
+func (p *meteredProvider) BatchEmbed(ctx context.Context, texts []string) ([][]float32, error) {
+    feature := p.featureOf(ctx)
+    r, err := p.meter.Reserve(ctx, feature, estimateParts(texts))
+    if err != nil {
+        return nil, err
+    }
+    v, err := p.inner.BatchEmbed(ctx, texts)
+    if err != nil {
+        r.Release()
+        return nil, err
+    }
+    settleEmbed(r, feature, texts)
+    return v, nil
+}
+
+func (p *meteredProvider) Close() error { return p.inner.Close() }
@@ -0,0 +1,100 @@
+package utils
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "reflect"
+    "testing"
+)
+
+// recordingMeter grants every reservation up to limit and records what was
+// reserved and settled.
+type recordingMeter struct {
+    limit    int64
+    reserved []int64
+    settled  []MeteredCall
+    released int
+}
+
+func (m *recordingMeter) Reserve(ctx context.Context, feature string, tokens int64) (Reservation, error) {
+    if m.limit > 0 && tokens > m.limit {
+        return nil, fmt.Errorf("%s: %w", feature, ErrQuotaExceeded)
+    }
+    m.reserved = append(m.reserved, tokens)
+    return &recordingReservation{m: m}, nil
+}
+
+type recordingReservation struct{ m *recordingMeter }
+
+func (r *recordingReservation) Settle(call MeteredCall) { r.m.settled = append(r.m.settled, call) }
+func (r *recordingReservation) Release()                { r.m.released++ }
+
+func TestMeteredFakeGenerate(t *testing.T) {
+    m := &recordingMeter{}
+    p := Metered(NewFakeProvider(), m, "chat")
+    ctx := WithFeature(context.Background(), "summary")
+
+    first, u1, err := p.GenerateWithUsage(ctx, "You are an analyst.", "Summarise   sales")
+    if err != nil {
+        t.Fatalf("GenerateWithUsage: %v", err)
+    }
+    second, u2, err := p.GenerateWithUsage(ctx, "You are an analyst.", "Summarise   sales")
+    if err != nil {
+        t.Fatalf("GenerateWithUsage: %v", err)
+    }
+    if first != "fake reply (Summarise sales)" || second != first || u1 != u2 {
+        t.Fatalf("replies = %q %+v, %q %+v; want the same fake reply twice", first, u1, second, u2)
+    }
+
+    // "You are an analyst." is 5 tokens and "Summarise   sales" 5, plus the reply allowance
+    if want := []int64{10 + outputAllowance, 10 + outputAllowance}; !reflect.DeepEqual(m.reserved, want) {
+        t.Errorf("reserved = %v, want %v", m.reserved, want)
+    }
+    want := MeteredCall{Feature: "summary", Kind: CallGenerate, Usage: u1}
+    if len(m.settled) != 2 || m.settled[0] != want || m.settled[1] != want || m.released != 0 {
+        t.Errorf("settled = %+v, released %d; want %+v twice", m.settled, m.released, want)
+    }
+}
+
+func TestMeteredFakeRefused(t *testing.T) {
+    calls := 0
+    fake := &FakeProvider{Respond: func(parts []string) string {
+        calls++
+        return "ok"
+    }}
+    m := &recordingMeter{limit: 100}
+    p := Metered(fake, m, "chat")
+
+    if _, err := p.Generate(context.Background(), "hello"); !errors.Is(err, ErrQuotaExceeded) {
+        t.Fatalf("Generate err = %v, want ErrQuotaExceeded", err)
+    }
+    if calls != 0 || len(m.settled) != 0 {
+        t.Fatalf("refused call reached the provider (%d calls, %d settled)", calls, len(m.settled))
+    }
+}
+
+func TestMeteredFakeEmbed(t *testing.T) {
+    m := &recordingMeter{}
+    p := Metered(NewFakeProvider(), m, "rag")
+
+    a, err := p.Embed(context.Background(), "coffee and cake")
+    if err != nil {
+        t.Fatalf("Embed: %v", err)
+    }
+    batch, err := p.BatchEmbed(context.Background(), []string{"Coffee and CAKE", "tea"})
+    if err != nil {
+        t.Fatalf("BatchEmbed: %v", err)
+    }
+    if len(a) != FakeEmbeddingDims || !reflect.DeepEqual(a, batch[0]) || reflect.DeepEqual(a, batch[1]) {
+        t.Fatalf("embeddings are not deterministic by word")
+    }
+
+    want := []MeteredCall{
+        {Feature: "rag", Kind: CallEmbed, Usage: Usage{PromptTokens: 4, TotalTokens: 4}, Estimated: true},
+        {Feature: "rag", Kind: CallEmbed, Usage: Usage{PromptTokens: 5, TotalTokens: 5}, Estimated: true},
+    }
+    if !reflect.DeepEqual(m.settled, want) {
+        t.Errorf("settled = %+v, want %+v", m.settled, want)
+    }
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// NumberFormat is how a column writes its amounts, inferred from the whole
+// column by DetectNumberFormat.
+type NumberFormat struct {
+    // DecimalComma is set for 1.234,56 style columns; otherwise '.' is the
+    // decimal point and ',' groups digits (1,234.56 or Indian 1,00,000.50).
+    DecimalComma bool `json:"decimal_comma"`
+    // NegativeSuffix is the Dr/Cr suffix that marks a negative amount: the
+    // less common of the two in the column, "dr" when neither dominates.
+    NegativeSuffix string `json:"negative_suffix"`
+}
+
+var (
+    // Currency symbols and codes accepted before or after an amount.
+    currencyPrefixRe = regexp.MustCompile(`(?i)^(rs\.?|inr|usd|eur|gbp|aed|sgd|₹|\$|€|£)\s*`)
+    currencySuffixRe = regexp.MustCompile(`(?i)\s*(rs\.?|inr|usd|eur|gbp|aed|sgd|₹|\$|€|£)$`)
+    drCrSuffixRe     = regexp.MustCompile(`(?i)\s*(dr|cr)\.?$`)
+)
+
+// DetectNumberFormat infers the decimal separator and Dr/Cr sign convention
+// from sample values of one column. Values with both separators, or with a
+// separator that cannot be grouping (12,5 or 1.234.567), decide the vote;
+// ambiguous ones such as 1,234 are ignored.
+func DetectNumberFormat(samples []string) NumberFormat {
+    f := NumberFormat{NegativeSuffix: "dr"}
+    commaVotes, dotVotes, dr, cr := 0, 0, 0, 0
+    for _, s := range samples {
+        t, _, suffix := stripAmount(s)
+        switch suffix {
+        case "dr":
+            dr++
+        case "cr":
+            cr++
+        }
+        lastComma, lastDot := strings.LastIndex(t, ","), strings.LastIndex(t, ".")
+        switch {
+        case lastComma >= 0 && lastDot >= 0:
+            if lastComma > lastDot {
+                commaVotes++
+            } else {
+                dotVotes++
+            }
+        case lastComma >= 0:
+            if strings.Count(t, ",") == 1 && len(t)-lastComma-1 != 3 {
+                commaVotes++
+            } else if strings.Count(t, ",") > 1 {
+                dotVotes++ // repeated commas can only be grouping
+            }
+        case lastDot >= 0:
+            if strings.Count(t, ".") > 1 {
+                commaVotes++
+            } else if len(t)-lastDot-1 != 3 {
+                dotVotes++
+            }
+        }
+    }
+    f.DecimalComma = commaVotes > dotVotes
+    if dr > cr {
+        f.NegativeSuffix = "cr"
+    }
+    return f
+}
+
+// ParseAmount reads a money amount written in format f. It accepts currency
+// symbols and codes, digit grouping (including lakh grouping and spaces or
+// apostrophes), leading or trailing minus, accounting parentheses and Dr/Cr
+// suffixes. ok is false when s is blank or is not an amount.
+func ParseAmount(s string, f NumberFormat) (float64, bool) {
+    t, neg, suffix := stripAmount(s)
+    if t == "" {
+        return 0, false
+    }
+    if suffix != "" && suffix == f.NegativeSuffix {
+        neg = !neg
+    }
+    // Raw spreadsheet values may use exponents (1.5E+07).
+    if strings.ContainsAny(t, "eE") {
+        v, err := strconv.ParseFloat(t, 64)
+        if err != nil {
+            return 0, false
+        }
+        if neg {
+            v = -v
+        }
+        return v, true
+    }
+    decimal, group := ".", ","
+    if f.DecimalComma {
+        decimal, group = ",", "."
+    }
+    // A value that contradicts the column (1.5 in a decimal-comma column)
+    // is read by its own last separator when that cannot be grouping.
+    if strings.Contains(t, decimal) && strings.Contains(t, group) {
+        if strings.LastIndex(t, group) > strings.LastIndex(t, decimal) {
+            decimal, group = group, decimal
+        }
+    } else if !strings.Contains(t, decimal) && strings.Count(t, group) == 1 && len(t)-strings.LastIndex(t, group)-1 != 3 {
+        decimal, group = group, decimal
+    }
+    var b strings.Builder
+    for _, r := range t {
+        switch {
+        case r >= '0' && r <= '9':
+            b.WriteRune(r)
+        case string(r) == decimal:
+            b.WriteByte('.')
+        case string(r) == group || r == ' ' || r == '\'' || r == '\u00a0' || r == '\u202f':
+            // grouping
+        default:
+            return 0, false
+        }
+    }
+    v, err := strconv.ParseFloat(b.String(), 64)
+    if err != nil {
+        return 0, false
+    }
+    if neg {
+        v = -v
+    }
+    return v, true
+}
+
+// stripAmount removes sign markers, Dr/Cr and currency from s, returning the
+// bare number text, whether a minus or parentheses made it negative, and the
+// lower-cased Dr/Cr suffix if any.
+func stripAmount(s string) (t string, neg bool, suffix string) {
+    t = strings.TrimSpace(s)
+    if m := drCrSuffixRe.FindStringSubmatch(t); m != nil {
+        suffix = strings.ToLower(m[1])
+        t = strings.TrimSpace(t[:len(t)-len(m[0])])
+    }
+    for changed := true; changed && t != ""; {
+        changed = false
+        if strings.HasPrefix(t, "(") && strings.HasSuffix(t, ")") {
+            t, neg, changed = strings.TrimSpace(t[1:len(t)-1]), !neg, true
+        }
+        for _, m := range []string{"-", "−"} {
+            if strings.HasPrefix(t, m) {
+                t, neg, changed = strings.TrimSpace(strings.TrimPrefix(t, m)), !neg, true
+            } else if strings.HasSuffix(t, m) {
+                t, neg, changed = strings.TrimSpace(strings.TrimSuffix(t, m)), !neg, true
+            }
+        }
+        if strings.HasPrefix(t, "+") {
+            t, changed = strings.TrimSpace(t[1:]), true
+        }
+        if loc := currencyPrefixRe.FindStringIndex(t); loc != nil && loc[1] > 0 {
+            t, changed = strings.TrimSpace(t[loc[1]:]), true
+        }
+        if loc := currencySuffixRe.FindStringIndex(t); loc != nil && loc[0] < len(t) {
+            t, changed = strings.TrimSpace(t[:loc[0]]), true
+        }
+    }
+    return t, neg, suffix
+}
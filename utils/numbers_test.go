@@ -0,0 +1,70 @@
+package utils
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+    dot := NumberFormat{NegativeSuffix: "dr"}
+    comma := NumberFormat{DecimalComma: true, NegativeSuffix: "dr"}
+    crNegative := NumberFormat{NegativeSuffix: "cr"}
+    cases := []struct {
+        in   string
+        f    NumberFormat
+        want float64
+        ok   bool
+    }{
+        {"1234.56", dot, 1234.56, true},
+        {"1,234.56", dot, 1234.56, true},
+        {"1.234,56", comma, 1234.56, true},
+        {"12,5", comma, 12.5, true},
+        {"1,00,000.50", dot, 100000.5, true}, // lakh grouping
+        {"₹ 1,00,000", dot, 100000, true},
+        {"(1,200)", dot, -1200, true},
+        {"1,200-", dot, -1200, true}, // trailing minus
+        {"-Rs. 50", dot, -50, true},
+        {"500 Dr", dot, -500, true},
+        {"500 Cr", dot, 500, true},
+        {"500 Dr", crNegative, 500, true},
+        {"500 Cr.", crNegative, -500, true},
+        {"1 234,50", comma, 1234.5, true},
+        {"1.5E+07", dot, 15000000, true},
+        // Ambiguous 1,234 follows the column's convention
+        {"1,234", dot, 1234, true},
+        {"1,234", comma, 1.234, true},
+        // A value contradicting the column is read by its own separators
+        {"1,234.50", comma, 1234.5, true},
+        {"12,5", dot, 12.5, true},
+        {"", dot, 0, false},
+        {"n/a", dot, 0, false},
+        {"12abc", dot, 0, false},
+    }
+    for _, tc := range cases {
+        got, ok := ParseAmount(tc.in, tc.f)
+        if ok != tc.ok || got != tc.want {
+            t.Errorf("ParseAmount(%q, %+v) = %v, %v; want %v, %v", tc.in, tc.f, got, ok, tc.want, tc.ok)
+        }
+    }
+}
+
+func TestDetectNumberFormat(t *testing.T) {
+    cases := []struct {
+        name    string
+        samples []string
+        want    NumberFormat
+    }{
+        {"decimal dot", []string{"1,234.56", "99.90", "12"}, NumberFormat{NegativeSuffix: "dr"}},
+        {"decimal comma", []string{"1.234,56", "99,90", "12"}, NumberFormat{DecimalComma: true, NegativeSuffix: "dr"}},
+        {"dotted thousands", []string{"1.234.567", "12"}, NumberFormat{DecimalComma: true, NegativeSuffix: "dr"}},
+        {"lakh grouping", []string{"1,00,000.50", "2,50,000"}, NumberFormat{NegativeSuffix: "dr"}},
+        // 1,234 could be either; it does not vote and the default stands
+        {"ambiguous only", []string{"1,234", "5,678"}, NumberFormat{NegativeSuffix: "dr"}},
+        {"ambiguous with one decider", []string{"1,234", "5,678", "3,5"}, NumberFormat{DecimalComma: true, NegativeSuffix: "dr"}},
+        // Ledgers mark the rarer side; mostly Dr means Cr is the negative one
+        {"mostly dr", []string{"500 Dr", "200 Dr", "50 Cr"}, NumberFormat{NegativeSuffix: "cr"}},
+        {"mostly cr", []string{"500 Cr", "200 Cr", "50 Dr"}, NumberFormat{NegativeSuffix: "dr"}},
+    }
+    for _, tc := range cases {
+        if got := DetectNumberFormat(tc.samples); got != tc.want {
+            t.Errorf("%s: DetectNumberFormat(%q) = %+v, want %+v", tc.name, tc.samples, got, tc.want)
+        }
+    }
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+
+    "golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes (OWASP baseline: 64 MiB, 3 passes).
+const (
+    argonTime    uint32 = 3
+    argonMemory  uint32 = 64 * 1024
+    argonThreads uint8  = 2
+    argonKeyLen  uint32 = 32
+    argonSaltLen        = 16
+)
+
+const argonPrefix = "$argon2id$"
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// HashPassword returns a PHC-formatted argon2id hash:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func HashPassword(password string) (string, error) {
+    salt := make([]byte, argonSaltLen)
+    if _, err := rand.Read(salt); err != nil {
+        return "", err
+    }
+    key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+    return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argonPrefix, argon2.Version, argonMemory, argonTime, argonThreads,
+        base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks password against a stored hash in constant time.
+// needsRehash is true when the match used a legacy unsalted sha256 hex digest
+// or argon2id parameters weaker than the current ones; callers should then
+// store HashPassword(password) in its place.
+func VerifyPassword(password, encoded string) (ok bool, needsRehash bool, err error) {
+    switch {
+    case strings.HasPrefix(encoded, argonPrefix):
+        return verifyArgon2id(password, encoded)
+    case isLegacySHA256(encoded):
+        sum := sha256.Sum256([]byte(password))
+        want := hex.EncodeToString(sum[:])
+        ok = subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(encoded))) == 1
+        return ok, ok, nil
+    default:
+        return false, false, ErrUnknownHashFormat
+    }
+}
+
+func isLegacySHA256(s string) bool {
+    if len(s) != sha256.Size*2 {
+        return false
+    }
+    _, err := hex.DecodeString(s)
+    return err == nil
+}
+
+func verifyArgon2id(password, encoded string) (bool, bool, error) {
+    // "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+    parts := strings.Split(encoded, "$")
+    if len(parts) != 6 {
+        return false, false, ErrUnknownHashFormat
+    }
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+        return false, false, ErrUnknownHashFormat
+    }
+    var memory, iterations uint32
+    var threads uint8
+    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+        return false, false, ErrUnknownHashFormat
+    }
+    salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+    if err != nil {
+        return false, false, ErrUnknownHashFormat
+    }
+    key, err := base64.RawStdEncoding.DecodeString(parts[5])
+    if err != nil || len(key) == 0 {
+        return false, false, ErrUnknownHashFormat
+    }
+    got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+    if subtle.ConstantTimeCompare(got, key) != 1 {
+        return false, false, nil
+    }
+    weaker := memory < argonMemory || iterations < argonTime || threads < argonThreads || uint32(len(key)) < argonKeyLen
+    return true, weaker, nil
+}
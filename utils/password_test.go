@@ -0,0 +1,66 @@
+package utils
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+    "testing"
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+    h, err := HashPassword("s3cret!")
+    if err != nil {
+        t.Fatalf("HashPassword: %v", err)
+    }
+    if !strings.HasPrefix(h, "$argon2id$v=19$") {
+        t.Fatalf("unexpected hash format: %s", h)
+    }
+    ok, rehash, err := VerifyPassword("s3cret!", h)
+    if err != nil || !ok || rehash {
+        t.Fatalf("VerifyPassword(correct) = %v, %v, %v", ok, rehash, err)
+    }
+    ok, _, err = VerifyPassword("wrong", h)
+    if err != nil || ok {
+        t.Fatalf("VerifyPassword(wrong) = %v, %v", ok, err)
+    }
+}
+
+func TestHashPasswordSalted(t *testing.T) {
+    a, _ := HashPassword("same")
+    b, _ := HashPassword("same")
+    if a == b {
+        t.Fatal("two hashes of the same password should differ")
+    }
+}
+
+func TestVerifyLegacySHA256(t *testing.T) {
+    sum := sha256.Sum256([]byte("legacy-pw"))
+    legacy := hex.EncodeToString(sum[:])
+
+    ok, rehash, err := VerifyPassword("legacy-pw", legacy)
+    if err != nil || !ok || !rehash {
+        t.Fatalf("legacy correct = %v, %v, %v; want true, true, nil", ok, rehash, err)
+    }
+    ok, rehash, err = VerifyPassword("nope", legacy)
+    if err != nil || ok || rehash {
+        t.Fatalf("legacy wrong = %v, %v, %v; want false, false, nil", ok, rehash, err)
+    }
+}
+
+func TestVerifyTamperedParameters(t *testing.T) {
+    h, _ := HashPassword("pw")
+    weak := strings.Replace(h, "m=65536,t=3", "m=65536,t=1", 1)
+    // Parameters are part of the derivation, so a tampered cost no longer matches.
+    if ok, _, _ := VerifyPassword("pw", weak); ok {
+        t.Fatal("hash with altered parameters must not verify")
+    }
+}
+
+func TestVerifyUnknownFormat(t *testing.T) {
+    if _, _, err := VerifyPassword("pw", "$2a$10$abcdefghijklmnopqrstuv"); err != ErrUnknownHashFormat {
+        t.Fatalf("err = %v, want ErrUnknownHashFormat", err)
+    }
+    if _, _, err := VerifyPassword("pw", ""); err != ErrUnknownHashFormat {
+        t.Fatalf("empty hash err = %v, want ErrUnknownHashFormat", err)
+    }
+}
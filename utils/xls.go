@@ -0,0 +1,358 @@
+package utils
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "unicode/utf16"
+
+    "github.com/richardlehane/mscfb"
+)
+
+// Sheet is one worksheet read from a legacy workbook.
+type Sheet struct {
+    Name string
+    Rows [][]string
+}
+
+// BIFF8 record types used by ReadXLS.
+const (
+    recBOF        = 0x0809
+    recEOF        = 0x000A
+    recBoundSheet = 0x0085
+    recSST        = 0x00FC
+    recContinue   = 0x003C
+    recLabelSST   = 0x00FD
+    recLabel      = 0x0204
+    recNumber     = 0x0203
+    recRK         = 0x027E
+    recMulRK      = 0x00BD
+    recFormula    = 0x0006
+    recString     = 0x0207
+    recBoolErr    = 0x0205
+)
+
+// ErrNotBIFF8 is returned for OLE2 files without a BIFF8 Workbook stream
+// (BIFF5 and older workbooks, or other Office documents).
+var ErrNotBIFF8 = errors.New("not an Excel 97-2003 (BIFF8) workbook")
+
+// ReadXLS reads every worksheet of an Excel 97-2003 .xls file. Numbers are
+// returned unformatted and dates as Excel serials, the same way the xlsx
+// path reads raw cell values.
+func ReadXLS(content []byte) ([]Sheet, error) {
+    doc, err := mscfb.New(bytes.NewReader(content))
+    if err != nil {
+        return nil, err
+    }
+    var wb []byte
+    for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
+        if entry.Name == "Workbook" {
+            // The size comes from the file; a stream cannot outgrow it
+            if entry.Size < 0 || entry.Size > int64(len(content)) {
+                return nil, fmt.Errorf("xls: workbook stream of %d bytes in a %d-byte file", entry.Size, len(content))
+            }
+            wb = make([]byte, entry.Size)
+            if _, err := io.ReadFull(entry, wb); err != nil {
+                return nil, err
+            }
+            break
+        }
+    }
+    if wb == nil {
+        return nil, ErrNotBIFF8
+    }
+    recs, err := splitRecords(wb)
+    if err != nil {
+        return nil, err
+    }
+
+    // Workbook globals: sheet directory and shared strings.
+    type sheetRef struct {
+        name   string
+        offset uint32
+    }
+    var refs []sheetRef
+    var sst []string
+    for i := 0; i < len(recs); i++ {
+        r := recs[i]
+        switch r.typ {
+        case recBOF:
+            if i == 0 && len(r.data) >= 2 && binary.LittleEndian.Uint16(r.data) != 0x0600 {
+                return nil, ErrNotBIFF8
+            }
+        case recBoundSheet:
+            if len(r.data) < 8 {
+                continue
+            }
+            // Only worksheets (type 0); skip charts and macro sheets.
+            if r.data[5] != 0 {
+                continue
+            }
+            sr := &segReader{segs: [][]byte{r.data[6:]}}
+            cch := int(sr.u8())
+            refs = append(refs, sheetRef{name: sr.chars(cch, sr.u8()&1 != 0), offset: binary.LittleEndian.Uint32(r.data)})
+        case recSST:
+            segs := [][]byte{r.data}
+            for i+1 < len(recs) && recs[i+1].typ == recContinue {
+                i++
+                segs = append(segs, recs[i].data)
+            }
+            sst = readSST(segs)
+        case recEOF:
+            i = len(recs) // globals end here
+        }
+    }
+
+    byOffset := make(map[uint32]int, len(recs))
+    for i, r := range recs {
+        byOffset[r.offset] = i
+    }
+    out := make([]Sheet, 0, len(refs))
+    for _, ref := range refs {
+        start, ok := byOffset[ref.offset]
+        if !ok {
+            return nil, fmt.Errorf("xls: sheet %q not found at offset %d", ref.name, ref.offset)
+        }
+        out = append(out, Sheet{Name: ref.name, Rows: readSheetCells(recs[start:], sst)})
+    }
+    return out, nil
+}
+
+type biffRecord struct {
+    typ    uint16
+    offset uint32
+    data   []byte
+}
+
+func splitRecords(b []byte) ([]biffRecord, error) {
+    var recs []biffRecord
+    for pos := 0; pos+4 <= len(b); {
+        typ := binary.LittleEndian.Uint16(b[pos:])
+        n := int(binary.LittleEndian.Uint16(b[pos+2:]))
+        if pos+4+n > len(b) {
+            return nil, errors.New("xls: truncated record")
+        }
+        recs = append(recs, biffRecord{typ: typ, offset: uint32(pos), data: b[pos+4 : pos+4+n]})
+        pos += 4 + n
+    }
+    return recs, nil
+}
+
+// readSheetCells collects cell values from a sheet's substream (BOF to EOF)
+// into dense rows.
+func readSheetCells(recs []biffRecord, sst []string) [][]string {
+    cells := map[[2]int]string{}
+    maxRow, maxCol := -1, -1
+    set := func(row, col int, v string) {
+        if v == "" {
+            return
+        }
+        cells[[2]int{row, col}] = v
+        if row > maxRow {
+            maxRow = row
+        }
+        if col > maxCol {
+            maxCol = col
+        }
+    }
+    pendingRow, pendingCol := -1, -1 // FORMULA whose string result follows in STRING
+    for i, r := range recs {
+        if i > 0 && r.typ == recEOF {
+            break
+        }
+        d := r.data
+        if r.typ != recString && r.typ != recContinue {
+            pendingRow = -1
+        }
+        if len(d) < 6 && r.typ != recString {
+            continue
+        }
+        switch r.typ {
+        case recLabelSST:
+            if len(d) >= 10 {
+                if idx := int(binary.LittleEndian.Uint32(d[6:])); idx < len(sst) {
+                    set(cellPos(d, sst[idx]))
+                }
+            }
+        case recLabel:
+            sr := &segReader{segs: [][]byte{d[6:]}}
+            cch := int(sr.u16())
+            row, col, _ := cellPos(d, "")
+            set(row, col, sr.chars(cch, sr.u8()&1 != 0))
+        case recNumber:
+            if len(d) >= 14 {
+                set(cellPos(d, formatNum(math.Float64frombits(binary.LittleEndian.Uint64(d[6:])))))
+            }
+        case recRK:
+            if len(d) >= 10 {
+                set(cellPos(d, formatNum(decodeRK(binary.LittleEndian.Uint32(d[6:])))))
+            }
+        case recMulRK:
+            row := int(binary.LittleEndian.Uint16(d))
+            col := int(binary.LittleEndian.Uint16(d[2:]))
+            for p := 4; p+6 <= len(d)-2; p += 6 {
+                set(row, col, formatNum(decodeRK(binary.LittleEndian.Uint32(d[p+2:]))))
+                col++
+            }
+        case recFormula:
+            if len(d) < 14 {
+                continue
+            }
+            row, col, _ := cellPos(d, "")
+            res := d[6:14]
+            if res[6] != 0xFF || res[7] != 0xFF {
+                set(row, col, formatNum(math.Float64frombits(binary.LittleEndian.Uint64(res))))
+                continue
+            }
+            switch res[0] {
+            case 0: // string result in the next STRING record
+                pendingRow, pendingCol = row, col
+            case 1:
+                set(row, col, strconv.FormatBool(res[2] != 0))
+            }
+        case recString:
+            if pendingRow >= 0 && len(d) >= 3 {
+                sr := &segReader{segs: [][]byte{d}}
+                cch := int(sr.u16())
+                set(pendingRow, pendingCol, sr.chars(cch, sr.u8()&1 != 0))
+            }
+            pendingRow = -1
+        case recBoolErr:
+            if len(d) >= 8 && d[7] == 0 {
+                set(cellPos(d, strconv.FormatBool(d[6] != 0)))
+            }
+        }
+    }
+    rows := make([][]string, maxRow+1)
+    for i := range rows {
+        rows[i] = []string{}
+    }
+    for rc, v := range cells {
+        row := rows[rc[0]]
+        for len(row) <= rc[1] {
+            row = append(row, "")
+        }
+        row[rc[1]] = v
+        rows[rc[0]] = row
+    }
+    return rows
+}
+
+// cellPos reads the row/col every cell record starts with.
+func cellPos(d []byte, v string) (int, int, string) {
+    return int(binary.LittleEndian.Uint16(d)), int(binary.LittleEndian.Uint16(d[2:])), v
+}
+
+func decodeRK(rk uint32) float64 {
+    var v float64
+    if rk&0x02 != 0 {
+        v = float64(int32(rk) >> 2)
+    } else {
+        v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+    }
+    if rk&0x01 != 0 {
+        v /= 100
+    }
+    return v
+}
+
+func formatNum(f float64) string {
+    return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// readSST decodes the shared string table, which may run on into CONTINUE
+// records (segs[1:]).
+func readSST(segs [][]byte) []string {
+    sr := &segReader{segs: segs}
+    sr.u32() // total references
+    n := int(sr.u32())
+    // The count comes from the file; each string takes at least 3 bytes
+    size := 0
+    for _, s := range segs {
+        size += len(s)
+    }
+    out := make([]string, 0, min(n, size/3))
+    for i := 0; i < n && !sr.eof(); i++ {
+        cch := int(sr.u16())
+        flags := sr.u8()
+        runs, ext := 0, 0
+        if flags&0x08 != 0 {
+            runs = int(sr.u16())
+        }
+        if flags&0x04 != 0 {
+            ext = int(sr.u32())
+        }
+        out = append(out, sr.chars(cch, flags&0x01 != 0))
+        sr.skip(4*runs + ext)
+    }
+    return out
+}
+
+// segReader reads little-endian values across record boundaries.
+type segReader struct {
+    segs [][]byte
+    seg  int
+    pos  int
+}
+
+func (r *segReader) eof() bool {
+    for r.seg < len(r.segs) && r.pos >= len(r.segs[r.seg]) {
+        r.seg++
+        r.pos = 0
+    }
+    return r.seg >= len(r.segs)
+}
+
+func (r *segReader) u8() byte {
+    if r.eof() {
+        return 0
+    }
+    b := r.segs[r.seg][r.pos]
+    r.pos++
+    return b
+}
+
+func (r *segReader) u16() uint16 {
+    return uint16(r.u8()) | uint16(r.u8())<<8
+}
+
+func (r *segReader) u32() uint32 {
+    return uint32(r.u16()) | uint32(r.u16())<<16
+}
+
+func (r *segReader) skip(n int) {
+    for ; n > 0 && !r.eof(); n-- {
+        r.pos++
+    }
+}
+
+// chars reads cch characters, 1 byte (Latin-1) or 2 bytes (UTF-16LE) each.
+// When the characters cross into a CONTINUE record, that record starts with
+// a fresh option byte saying which width the rest uses.
+func (r *segReader) chars(cch int, wide bool) string {
+    u := make([]uint16, 0, cch)
+    for len(u) < cch {
+        if r.seg < len(r.segs) && r.pos >= len(r.segs[r.seg]) {
+            r.seg++
+            r.pos = 0
+            if r.seg >= len(r.segs) {
+                break
+            }
+            wide = r.u8()&0x01 != 0
+            continue
+        }
+        if r.seg >= len(r.segs) {
+            break
+        }
+        if wide {
+            u = append(u, r.u16())
+        } else {
+            u = append(u, uint16(r.u8()))
+        }
+    }
+    return string(utf16.Decode(u))
+}
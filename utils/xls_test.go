@@ -0,0 +1,101 @@
+package utils
+
+import (
+    "encoding/binary"
+    "reflect"
+    "strings"
+    "testing"
+    "unicode/utf16"
+)
+
+// oleWithWorkbook builds a minimal compound file (one FAT sector, one
+// directory sector) whose Workbook entry claims size bytes.
+func oleWithWorkbook(size uint32) []byte {
+    const end, free, fatSect = 0xFFFFFFFE, 0xFFFFFFFF, 0xFFFFFFFD
+    b := make([]byte, 3*512)
+    le := binary.LittleEndian
+    le.PutUint64(b, 0xE11AB1A1E011CFD0)
+    le.PutUint16(b[24:], 0x3E)
+    le.PutUint16(b[26:], 3)
+    le.PutUint16(b[28:], 0xFFFE)
+    le.PutUint16(b[30:], 9)
+    le.PutUint16(b[32:], 6)
+    le.PutUint32(b[44:], 1)   // one FAT sector
+    le.PutUint32(b[48:], 1)   // directory in sector 1
+    le.PutUint32(b[56:], 4096)
+    le.PutUint32(b[60:], end) // no mini FAT
+    le.PutUint32(b[68:], end) // no DIFAT sectors
+    for i := 76; i < 512; i += 4 {
+        le.PutUint32(b[i:], free)
+    }
+    le.PutUint32(b[76:], 0) // FAT in sector 0
+
+    fat := b[512:1024]
+    for i := 0; i < 512; i += 4 {
+        le.PutUint32(fat[i:], free)
+    }
+    le.PutUint32(fat, fatSect)
+    le.PutUint32(fat[4:], end)
+
+    dir := b[1024:]
+    entry := func(i int, name string, typ byte, child, start, size uint32) {
+        e := dir[i*128:]
+        for j, c := range utf16.Encode([]rune(name)) {
+            le.PutUint16(e[2*j:], c)
+        }
+        if name != "" {
+            le.PutUint16(e[64:], uint16(2*len(name)+2))
+        }
+        e[66], e[67] = typ, 1
+        le.PutUint32(e[68:], free)
+        le.PutUint32(e[72:], free)
+        le.PutUint32(e[76:], child)
+        le.PutUint32(e[116:], start)
+        le.PutUint32(e[120:], size)
+    }
+    entry(0, "Root Entry", 5, 1, end, 0)
+    entry(1, "Workbook", 2, free, end, size)
+    entry(2, "", 0, free, end, 0)
+    entry(3, "", 0, free, end, 0)
+    return b
+}
+
+func TestReadSSTBogusCount(t *testing.T) {
+    // Claims 0xFFFFFFFF strings but holds one; must not size by the claim
+    segs := [][]byte{{0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 2, 0, 0, 'h', 'i'}}
+    if got := readSST(segs); !reflect.DeepEqual(got, []string{"hi"}) {
+        t.Fatalf("readSST = %q, want [hi]", got)
+    }
+}
+
+func TestReadSSTContinue(t *testing.T) {
+    // "abcd" split across a CONTINUE record that switches to UTF-16
+    segs := [][]byte{
+        {0, 0, 0, 0, 2, 0, 0, 0, 4, 0, 0, 'a', 'b'},
+        {1, 'c', 0, 'd', 0, 1, 0, 0, 'e'},
+    }
+    if got := readSST(segs); !reflect.DeepEqual(got, []string{"abcd", "e"}) {
+        t.Fatalf("readSST = %q, want [abcd e]", got)
+    }
+}
+
+func TestReadXLSWorkbookSize(t *testing.T) {
+    // A Workbook entry claiming ~4 GB must be refused before allocating
+    _, err := ReadXLS(oleWithWorkbook(0xFFFFFFF0))
+    if err == nil || !strings.Contains(err.Error(), "workbook stream") {
+        t.Fatalf("ReadXLS err = %v, want the workbook size refused", err)
+    }
+}
+
+func TestReadXLSMalformed(t *testing.T) {
+    cases := map[string][]byte{
+        "empty":     {},
+        "not ole2":  []byte("Bill,Amount\n1,2\n"),
+        "truncated": {0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1, 0, 0},
+    }
+    for name, content := range cases {
+        if _, err := ReadXLS(content); err == nil {
+            t.Errorf("%s: ReadXLS returned no error", name)
+        }
+    }
+}